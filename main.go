@@ -54,7 +54,9 @@ func main() {
 		log.Fatal("error creating client:", err.Error())
 	}
 
-	reddit, err := redditbot.NewReddit(cfg.Reddit)
+	redditCtx, redditCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer redditCancel()
+	reddit, err := redditbot.NewReddit(redditCtx, cfg.Reddit)
 	if err != nil {
 		log.Fatal("error creating reddit client:", err.Error())
 	}
@@ -106,7 +108,7 @@ func main() {
 		}
 	}
 
-	b.Client.AddEventListeners(bot.NewListenerFunc(b.OnApplicationCommand))
+	b.Client.AddEventListeners(bot.NewListenerFunc(b.OnApplicationCommand), bot.NewListenerFunc(b.OnAutocomplete))
 
 	if cfg.Discord.SyncCommands {
 		if _, err = client.Rest().SetGlobalCommands(client.ApplicationID(), redditbot.Commands); err != nil {
@@ -120,7 +122,27 @@ func main() {
 		log.Fatal("error opening gateway:", err.Error())
 	}
 
-	go b.ListenSubreddits()
+	listenCtx, listenCancel := context.WithCancel(context.Background())
+	leasesDone := make(chan struct{})
+	listenDone := make(chan struct{})
+	outboxDone := make(chan struct{})
+	refreshDone := make(chan struct{})
+	go func() {
+		defer close(leasesDone)
+		b.ListenLeases(listenCtx)
+	}()
+	go func() {
+		defer close(listenDone)
+		b.ListenSubreddits(listenCtx)
+	}()
+	go func() {
+		defer close(outboxDone)
+		b.ListenOutbox(listenCtx)
+	}()
+	go func() {
+		defer close(refreshDone)
+		b.ListenRefresh(listenCtx)
+	}()
 
 	if cfg.Server.Enabled {
 		go b.ListenAndServe()
@@ -137,4 +159,12 @@ func main() {
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, syscall.SIGINT, syscall.SIGTERM)
 	<-s
+
+	log.Info("waiting for subreddit listener and outbox to stop...")
+	listenCancel()
+	<-listenDone
+	<-outboxDone
+	<-refreshDone
+	<-leasesDone
+	b.ReleaseLeases()
 }
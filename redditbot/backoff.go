@@ -0,0 +1,19 @@
+package redditbot
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff returns the jittered exponential delay before retry number attempt (starting at 0).
+// The delay doubles with every attempt up to max and is randomized between half and the full delay.
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/log"
+	"github.com/disgoorg/snowflake/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"golang.org/x/oauth2"
@@ -30,11 +31,39 @@ var redditRequests = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "The number of requests made to the Reddit API",
 }, []string{"path", "method", "status", "important", "sleep", "used", "remaining", "reset"})
 
+var deliveryQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "redditbot_delivery_queue_depth",
+	Help: "The number of posts waiting for a delivery worker",
+})
+
+var deliveryLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "redditbot_delivery_latency_seconds",
+	Help:    "The time between a post being added to the outbox and it being sent to Discord",
+	Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+})
+
 type SetupState struct {
-	Subreddit   string
-	PostType    string
-	FormatType  FormatType
-	Interaction discord.ApplicationCommandInteraction
+	Subreddit         string
+	PostType          string
+	TimeWindow        string
+	FormatType        FormatType
+	RemovedPosts      RemovedPostAction
+	PostKinds         string
+	IncludePatterns   string
+	ExcludePatterns   string
+	FlairAllowlist    string
+	FlairDenylist     string
+	AllowNSFW         bool
+	AuthorAllowlist   string
+	AuthorDenylist    string
+	AnnouncementsOnly bool
+	DomainAllowlist   string
+	DomainDenylist    string
+	FilterExpression  string
+	MinScore          int
+	MinComments       int
+	MinUpvoteRatio    float64
+	Interaction       discord.ApplicationCommandInteraction
 }
 
 type Bot struct {
@@ -49,6 +78,10 @@ type Bot struct {
 	Rand          *rand.Rand
 
 	States map[string]SetupState
+
+	flairs   ttlCache[string, []string]
+	channels ttlCache[snowflake.ID, bool]
+	filters  ttlCache[snowflake.ID, cachedFilter]
 }
 
 func (b *Bot) randomString(length int) string {
@@ -74,7 +107,9 @@ func (b *Bot) ListenAndServeMetrics() {
 func (b *Bot) Close() {
 	b.Client.Close(context.Background())
 	_ = b.DB.Close()
-	_ = b.Server.Shutdown(context.Background())
+	if b.Server != nil {
+		_ = b.Server.Shutdown(context.Background())
+	}
 	if b.MetricsServer != nil {
 		_ = b.MetricsServer.Shutdown(context.Background())
 	}
@@ -0,0 +1,71 @@
+package redditbot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/disgoorg/log"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failed requests after which the circuit breaker opens
+	breakerThreshold = 5
+	// breakerMinCooldown is how long the circuit breaker stays open the first time, it doubles every time it opens again without a successful request in between
+	breakerMinCooldown = time.Minute
+	// breakerMaxCooldown caps how long the circuit breaker stays open
+	breakerMaxCooldown = 15 * time.Minute
+)
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		cooldown: breakerMinCooldown,
+	}
+}
+
+// circuitBreaker stops all requests to Reddit for a while when too many requests failed in a row.
+// After the cooldown requests are let through again, if the next one fails too it opens again right away.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+// OpenFor returns how long the circuit breaker stays open, 0 if it's closed.
+func (c *circuitBreaker) OpenFor() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wait := c.openUntil.Sub(time.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (c *circuitBreaker) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures >= breakerThreshold {
+		log.Info("reddit is reachable again, resuming requests")
+	}
+	c.failures = 0
+	c.cooldown = breakerMinCooldown
+}
+
+func (c *circuitBreaker) Failure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	if c.failures < breakerThreshold {
+		return
+	}
+
+	c.openUntil = time.Now().Add(c.cooldown)
+	log.Warnf("reddit seems to be down after %d failed requests, pausing requests for %s", c.failures, c.cooldown)
+	c.cooldown *= 2
+	if c.cooldown > breakerMaxCooldown {
+		c.cooldown = breakerMaxCooldown
+	}
+}
@@ -0,0 +1,49 @@
+package redditbot
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache keeps values for a limited time. The zero value is an empty cache ready to use.
+type ttlCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]ttlCacheEntry[V]
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// get returns the value of a key if it's cached and didn't expire yet.
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set caches the value of a key for ttl and removes all expired values.
+func (c *ttlCache[K, V]) set(key K, value V, ttl time.Duration) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[K]ttlCacheEntry[V]{}
+	}
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlCacheEntry[V]{
+		value:   value,
+		expires: now.Add(ttl),
+	}
+}
@@ -0,0 +1,37 @@
+package redditbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// channelCacheTTL is how long the age restriction of a channel is cached.
+const channelCacheTTL = 10 * time.Minute
+
+// isChannelNSFW returns whether a channel is age-restricted, it's cached so it doesn't have to be requested for every check.
+// Failed lookups aren't cached, so they are tried again with the next check.
+func (b *Bot) isChannelNSFW(ctx context.Context, channelID snowflake.ID) (bool, error) {
+	if nsfw, ok := b.channels.get(channelID); ok {
+		return nsfw, nil
+	}
+
+	channel, err := b.Client.Rest().GetChannel(channelID, rest.WithCtx(ctx))
+	if err != nil {
+		return false, err
+	}
+	nsfw := isNSFWChannel(channel)
+	b.channels.set(channelID, nsfw, channelCacheTTL)
+	return nsfw, nil
+}
+
+// isNSFWChannel returns whether a channel is age-restricted, channels without this setting never are.
+func isNSFWChannel(channel discord.Channel) bool {
+	if c, ok := channel.(interface{ NSFW() bool }); ok {
+		return c.NSFW()
+	}
+	return false
+}
@@ -3,8 +3,10 @@ package redditbot
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/disgoorg/log"
 
@@ -14,11 +16,28 @@ import (
 	"github.com/knadh/koanf/v2"
 )
 
+// configDefaults are applied before the config file is loaded so options added in later versions don't have to be present in existing config files.
+var configDefaults = map[string]any{
+	"discord.max_delivery_attempts":  8,
+	"discord.delivery_workers":       4,
+	"reddit.token_url":               "https://www.reddit.com/api/v1/access_token",
+	"reddit.api_url":                 "https://oauth.reddit.com",
+	"reddit.user_agent":              "discord:com.github.topi314.reddit-discord-bot:1.0.0 (by /u/TobiDragneel)",
+	"reddit.seen_post_retention":     "168h",
+	"reddit.max_combined_subreddits": 25,
+	"reddit.min_check_interval":      "1m",
+	"reddit.max_check_interval":      "30m",
+	"reddit.suspension_grace_period": "336h",
+	"reddit.refresh_interval":        "10m",
+	"reddit.refresh_window":          "24h",
+}
+
 func ReadConfig() (Config, error) {
 	f := flag.NewFlagSet("config", flag.ExitOnError)
 	path := f.String("config", "./config.yml", "Endpoint to config file (default: ./config.yml)")
 
 	f.Bool("test_mode", false, "Test mode (default: false)")
+	f.String("replica_id", "", "ID of this replica when running several instances on one database (default: hostname)")
 
 	f.Int("log.level", 2, "Log level (0: trace, 1: debug, 2: info, 3: warn, 4: error, 5: fatal, 6: panic)")
 	f.Bool("log.add_source", false, "Add source to log ")
@@ -31,11 +50,23 @@ func ReadConfig() (Config, error) {
 	f.String("discord.token", "", "Discord bot token")
 	f.String("discord.client_secret", "", "Discord client secret")
 	f.Bool("discord.sync_commands", true, "Sync Discord commands (default: true)")
+	f.Int("discord.max_delivery_attempts", 8, "How often sending a post to a webhook is tried before giving up (default: 8)")
+	f.Int("discord.delivery_workers", 4, "Number of workers sending posts to webhooks (default: 4)")
 
 	f.String("reddit.client_id", "", "Reddit client ID")
 	f.String("reddit.client_secret", "", "Reddit client secret")
+	f.String("reddit.token_url", "https://www.reddit.com/api/v1/access_token", "Reddit OAuth2 token URL (default: https://www.reddit.com/api/v1/access_token)")
+	f.String("reddit.api_url", "https://oauth.reddit.com", "Reddit API base URL (default: https://oauth.reddit.com)")
+	f.String("reddit.user_agent", "discord:com.github.topi314.reddit-discord-bot:1.0.0 (by /u/TobiDragneel)", "User-Agent sent to Reddit")
 	f.Int("reddit.requests_per_minute", 59, "Reddit requests per minute (default: 59)")
 	f.Int("reddit.max_pages", 2, "Reddit max pages (default: 2)")
+	f.Int("reddit.max_combined_subreddits", 25, "Max subreddits combined into one request, 0 disables combining (default: 25)")
+	f.Duration("reddit.min_check_interval", time.Minute, "Min time between checks of a busy subreddit (default: 1m)")
+	f.Duration("reddit.max_check_interval", 30*time.Minute, "Max time between checks of a quiet subreddit (default: 30m)")
+	f.Duration("reddit.suspension_grace_period", 14*24*time.Hour, "How long a subscription to an inaccessible subreddit is kept (default: 336h)")
+	f.Duration("reddit.seen_post_retention", 7*24*time.Hour, "How long delivered posts are remembered, top and controversial subscriptions keep them for their time window (default: 168h)")
+	f.Duration("reddit.refresh_interval", 10*time.Minute, "How often delivered posts are checked for edits, removals and new scores (default: 10m)")
+	f.Duration("reddit.refresh_window", 24*time.Hour, "How long delivered posts are refreshed (default: 24h)")
 
 	f.String("database.type", string(DatabaseTypeSQLite), "Database type (sqlite, postgres)")
 
@@ -58,6 +89,12 @@ func ReadConfig() (Config, error) {
 	}
 
 	k := koanf.New(".")
+	for key, value := range configDefaults {
+		if err := k.Set(key, value); err != nil {
+			return Config{}, err
+		}
+	}
+
 	log.Info("Loading config from:", *path)
 	if err := k.Load(file.Provider(*path), yaml.Parser()); err != nil {
 		return Config{}, err
@@ -74,23 +111,36 @@ func ReadConfig() (Config, error) {
 	// }
 
 	var config Config
-	err := k.Unmarshal("", &config)
-	return config, err
+	if err := k.Unmarshal("", &config); err != nil {
+		return Config{}, err
+	}
+
+	if config.ReplicaID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return Config{}, fmt.Errorf("error getting hostname for replica_id: %w", err)
+		}
+		config.ReplicaID = hostname
+	}
+
+	return config, nil
 }
 
 type Config struct {
-	TestMode bool           `koanf:"test_mode"`
-	Log      LogConfig      `koanf:"log"`
-	Server   ServerConfig   `koanf:"server"`
-	Discord  DiscordConfig  `koanf:"discord"`
-	Reddit   RedditConfig   `koanf:"reddit"`
-	Database DatabaseConfig `koanf:"database"`
-	Metrics  MetricsConfig  `koanf:"metrics"`
+	TestMode  bool           `koanf:"test_mode"`
+	ReplicaID string         `koanf:"replica_id"`
+	Log       LogConfig      `koanf:"log"`
+	Server    ServerConfig   `koanf:"server"`
+	Discord   DiscordConfig  `koanf:"discord"`
+	Reddit    RedditConfig   `koanf:"reddit"`
+	Database  DatabaseConfig `koanf:"database"`
+	Metrics   MetricsConfig  `koanf:"metrics"`
 }
 
 func (c Config) String() string {
-	return fmt.Sprintf("\nTestMode: %t\nLog: %s\nServer: %s\nDiscord: %s\nReddit: %v\nDatabase: %s\nMetrics: %s",
+	return fmt.Sprintf("\nTestMode: %t\nReplicaID: %s\nLog: %s\nServer: %s\nDiscord: %s\nReddit: %v\nDatabase: %s\nMetrics: %s",
 		c.TestMode,
+		c.ReplicaID,
 		c.Log,
 		c.Server,
 		c.Discord,
@@ -179,16 +229,20 @@ func (c ServerConfig) Validate() error {
 }
 
 type DiscordConfig struct {
-	Token        string `koanf:"token"`
-	ClientSecret string `koanf:"client_secret"`
-	SyncCommands bool   `koanf:"sync_commands"`
+	Token               string `koanf:"token"`
+	ClientSecret        string `koanf:"client_secret"`
+	SyncCommands        bool   `koanf:"sync_commands"`
+	MaxDeliveryAttempts int    `koanf:"max_delivery_attempts"`
+	DeliveryWorkers     int    `koanf:"delivery_workers"`
 }
 
 func (c DiscordConfig) String() string {
-	return fmt.Sprintf("\n  Token: %s\n  ClientSecret: %s\n  SyncCommands: %t",
+	return fmt.Sprintf("\n  Token: %s\n  ClientSecret: %s\n  SyncCommands: %t\n  MaxDeliveryAttempts: %d\n  DeliveryWorkers: %d",
 		strings.Repeat("*", len(c.Token)),
 		strings.Repeat("*", len(c.ClientSecret)),
 		c.SyncCommands,
+		c.MaxDeliveryAttempts,
+		c.DeliveryWorkers,
 	)
 }
 
@@ -199,21 +253,52 @@ func (c DiscordConfig) Validate() error {
 	if c.ClientSecret == "" {
 		return fmt.Errorf("discord.client_secret must be set")
 	}
+	if c.MaxDeliveryAttempts <= 0 {
+		return fmt.Errorf("discord.max_delivery_attempts must be greater than 0")
+	}
+	if c.DeliveryWorkers <= 0 {
+		return fmt.Errorf("discord.delivery_workers must be greater than 0")
+	}
 	return nil
 }
 
 type RedditConfig struct {
-	ClientID          string `koanf:"client_id"`
-	ClientSecret      string `koanf:"client_secret"`
-	RequestsPerMinute int    `koanf:"requests_per_minute"`
-	MaxPages          int    `koanf:"max_pages"`
+	ClientID              string        `koanf:"client_id"`
+	ClientSecret          string        `koanf:"client_secret"`
+	TokenURL              string        `koanf:"token_url"`
+	APIURL                string        `koanf:"api_url"`
+	UserAgent             string        `koanf:"user_agent"`
+	RequestsPerMinute     int           `koanf:"requests_per_minute"`
+	MaxPages              int           `koanf:"max_pages"`
+	SeenPostRetention     time.Duration `koanf:"seen_post_retention"`
+	MaxCombinedSubreddits int           `koanf:"max_combined_subreddits"`
+	MinCheckInterval      time.Duration `koanf:"min_check_interval"`
+	MaxCheckInterval      time.Duration `koanf:"max_check_interval"`
+	SuspensionGracePeriod time.Duration `koanf:"suspension_grace_period"`
+	RefreshInterval       time.Duration `koanf:"refresh_interval"`
+	RefreshWindow         time.Duration `koanf:"refresh_window"`
+
+	// Transport is used for all requests to Reddit, defaults to http.DefaultTransport.
+	// It can't be set from the config file and is meant for running the bot against a fake Reddit.
+	Transport http.RoundTripper `koanf:"-"`
 }
 
 func (c RedditConfig) String() string {
-	return fmt.Sprintf("\n  ClientID: %s\n  ClientSecret: %s\n  RequestsPerMinute: %d",
+	return fmt.Sprintf("\n  ClientID: %s\n  ClientSecret: %s\n  TokenURL: %s\n  APIURL: %s\n  UserAgent: %s\n  RequestsPerMinute: %d\n  MaxPages: %d\n  SeenPostRetention: %s\n  MaxCombinedSubreddits: %d\n  MinCheckInterval: %s\n  MaxCheckInterval: %s\n  SuspensionGracePeriod: %s\n  RefreshInterval: %s\n  RefreshWindow: %s",
 		c.ClientID,
 		strings.Repeat("*", len(c.ClientSecret)),
+		c.TokenURL,
+		c.APIURL,
+		c.UserAgent,
 		c.RequestsPerMinute,
+		c.MaxPages,
+		c.SeenPostRetention,
+		c.MaxCombinedSubreddits,
+		c.MinCheckInterval,
+		c.MaxCheckInterval,
+		c.SuspensionGracePeriod,
+		c.RefreshInterval,
+		c.RefreshWindow,
 	)
 }
 
@@ -224,9 +309,39 @@ func (c RedditConfig) Validate() error {
 	if c.ClientSecret == "" {
 		return fmt.Errorf("reddit.client_secret must be set")
 	}
+	if c.TokenURL == "" {
+		return fmt.Errorf("reddit.token_url must be set")
+	}
+	if c.APIURL == "" {
+		return fmt.Errorf("reddit.api_url must be set")
+	}
+	if c.UserAgent == "" {
+		return fmt.Errorf("reddit.user_agent must be set")
+	}
 	if c.RequestsPerMinute <= 0 {
 		return fmt.Errorf("reddit.requests_per_minute must be greater than 0")
 	}
+	if c.SeenPostRetention <= 0 {
+		return fmt.Errorf("reddit.seen_post_retention must be greater than 0")
+	}
+	if c.MaxCombinedSubreddits < 0 {
+		return fmt.Errorf("reddit.max_combined_subreddits must not be negative")
+	}
+	if c.MinCheckInterval <= 0 {
+		return fmt.Errorf("reddit.min_check_interval must be greater than 0")
+	}
+	if c.MaxCheckInterval < c.MinCheckInterval {
+		return fmt.Errorf("reddit.max_check_interval must not be less than reddit.min_check_interval")
+	}
+	if c.SuspensionGracePeriod < 0 {
+		return fmt.Errorf("reddit.suspension_grace_period must not be negative")
+	}
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("reddit.refresh_interval must be greater than 0")
+	}
+	if c.RefreshWindow < 0 {
+		return fmt.Errorf("reddit.refresh_window must not be negative")
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package redditbot
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/disgoorg/snowflake/v2"
@@ -13,6 +14,8 @@ import (
 
 var (
 	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrLeaseLost            = errors.New("subscription is not leased by this replica anymore")
+	ErrOutboxEntryNotFound  = errors.New("outbox entry not found")
 )
 
 type FormatType string
@@ -22,15 +25,81 @@ const (
 	FormatTypeText  FormatType = "text"
 )
 
+// RemovedPostAction is what happens to the Discord message of a post which was removed from Reddit.
+type RemovedPostAction string
+
+const (
+	RemovedPostActionMark   RemovedPostAction = "mark"
+	RemovedPostActionDelete RemovedPostAction = "delete"
+)
+
 type Subscription struct {
-	Subreddit    string       `db:"subreddit"`
-	Type         string       `db:"type"`
-	FormatType   FormatType   `db:"format_type"`
-	GuildID      snowflake.ID `db:"guild_id"`
-	ChannelID    snowflake.ID `db:"channel_id"`
-	WebhookID    snowflake.ID `db:"webhook_id"`
-	WebhookToken string       `db:"webhook_token"`
-	LastPost     time.Time    `db:"last_post"`
+	Subreddit      string            `db:"subreddit"`
+	Type           string            `db:"type"`
+	FormatType     FormatType        `db:"format_type"`
+	GuildID        snowflake.ID      `db:"guild_id"`
+	ChannelID      snowflake.ID      `db:"channel_id"`
+	WebhookID      snowflake.ID      `db:"webhook_id"`
+	WebhookToken   string            `db:"webhook_token"`
+	LastPost       time.Time         `db:"last_post"`
+	LastCheck      time.Time         `db:"last_check"`
+	NextCheck      time.Time         `db:"next_check"`
+	PostRate       float64           `db:"post_rate"`
+	Suspended      bool              `db:"suspended"`
+	SuspendedSince time.Time         `db:"suspended_since"`
+	Failures       int               `db:"failures"`
+	LastError      string            `db:"last_error"`
+	LeaseOwner     string            `db:"lease_owner"`
+	LeaseExpires   time.Time         `db:"lease_expires"`
+	RemovedPosts   RemovedPostAction `db:"removed_posts"`
+	// TimeWindow is the time window of "top" and "controversial" listings: hour, day, week, month, year or all
+	TimeWindow string `db:"time_window"`
+	// PostKinds is a comma separated list of the post kinds which are sent, empty means all kinds
+	PostKinds string `db:"post_kinds"`
+	// IncludePatterns and ExcludePatterns are comma separated keywords or /regex/ matched against the title and text of posts
+	IncludePatterns string `db:"include_patterns"`
+	ExcludePatterns string `db:"exclude_patterns"`
+	// FlairAllowlist and FlairDenylist are comma separated link flairs
+	FlairAllowlist string `db:"flair_allowlist"`
+	FlairDenylist  string `db:"flair_denylist"`
+	// AllowNSFW allows NSFW posts, they are only sent to age-restricted channels either way
+	AllowNSFW bool `db:"allow_nsfw"`
+	// AuthorAllowlist and AuthorDenylist are comma separated usernames without u/
+	AuthorAllowlist string `db:"author_allowlist"`
+	AuthorDenylist  string `db:"author_denylist"`
+	// AnnouncementsOnly only sends posts distinguished or stickied by the moderators
+	AnnouncementsOnly bool `db:"announcements_only"`
+	// DomainAllowlist and DomainDenylist are comma separated domains like example.com or *.example.com
+	DomainAllowlist string `db:"domain_allowlist"`
+	DomainDenylist  string `db:"domain_denylist"`
+	// FilterExpression is an expression posts have to match, see compileExpression
+	FilterExpression string `db:"filter_expression"`
+	// MinScore, MinComments and MinUpvoteRatio hold back posts of ranked listings until they reach them, 0 disables them
+	MinScore       int     `db:"min_score"`
+	MinComments    int     `db:"min_comments"`
+	MinUpvoteRatio float64 `db:"min_upvote_ratio"`
+}
+
+// OutboxEntry is a rendered post waiting to be delivered to a webhook.
+type OutboxEntry struct {
+	WebhookID   snowflake.ID `db:"webhook_id"`
+	PostName    string       `db:"post_name"`
+	PostCreated time.Time    `db:"post_created"`
+	Payload     string       `db:"payload"`
+	Attempts    int          `db:"attempts"`
+	NextAttempt time.Time    `db:"next_attempt"`
+	LastError   string       `db:"last_error"`
+	CreatedAt   time.Time    `db:"created_at"`
+}
+
+// DeliveredMessage is the Discord message a post was delivered as.
+type DeliveredMessage struct {
+	WebhookID   snowflake.ID `db:"webhook_id"`
+	PostName    string       `db:"post_name"`
+	MessageID   snowflake.ID `db:"message_id"`
+	Payload     string       `db:"payload"`
+	Removed     bool         `db:"removed"`
+	DeliveredAt time.Time    `db:"delivered_at"`
 }
 
 func NewDB(cfg DatabaseConfig, schema string) (*DB, error) {
@@ -58,9 +127,60 @@ func NewDB(cfg DatabaseConfig, schema string) (*DB, error) {
 		return nil, err
 	}
 
+	if err = migrateColumns(dbx); err != nil {
+		return nil, err
+	}
+
 	return &DB{dbx}, nil
 }
 
+// columnMigrations are columns which were added to the schema later on.
+// They are added to tables created by an older version of the schema.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"subscriptions", "last_check", "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+	{"subscriptions", "next_check", "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+	{"subscriptions", "post_rate", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{"subscriptions", "suspended", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"subscriptions", "suspended_since", "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+	{"subscriptions", "failures", "INT NOT NULL DEFAULT 0"},
+	{"subscriptions", "last_error", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "lease_owner", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "lease_expires", "TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00'"},
+	{"subscriptions", "removed_posts", "VARCHAR NOT NULL DEFAULT 'mark'"},
+	{"subscriptions", "post_kinds", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "include_patterns", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "exclude_patterns", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "flair_allowlist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "flair_denylist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "allow_nsfw", "BOOLEAN NOT NULL DEFAULT TRUE"},
+	{"subscriptions", "min_score", "INT NOT NULL DEFAULT 0"},
+	{"subscriptions", "min_comments", "INT NOT NULL DEFAULT 0"},
+	{"subscriptions", "min_upvote_ratio", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{"subscriptions", "time_window", "VARCHAR NOT NULL DEFAULT 'day'"},
+	{"subscriptions", "author_allowlist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "author_denylist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "announcements_only", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"subscriptions", "domain_allowlist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "domain_denylist", "VARCHAR NOT NULL DEFAULT ''"},
+	{"subscriptions", "filter_expression", "VARCHAR NOT NULL DEFAULT ''"},
+}
+
+func migrateColumns(dbx *sqlx.DB) error {
+	for _, m := range columnMigrations {
+		if _, err := dbx.Exec(fmt.Sprintf(`SELECT %s FROM %s LIMIT 0`, m.column, m.table)); err == nil {
+			continue
+		}
+		if _, err := dbx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	return nil
+}
+
 type DB struct {
 	dbx *sqlx.DB
 }
@@ -70,17 +190,29 @@ func (d *DB) Close() error {
 }
 
 func (d *DB) AddSubscription(sub Subscription) error {
-	_, err := d.dbx.NamedExec(`INSERT INTO subscriptions (subreddit, format_type, guild_id, channel_id, webhook_id, webhook_token) VALUES (:subreddit, :format_type, :guild_id, :channel_id, :webhook_id, :webhook_token)`, sub)
+	_, err := d.dbx.NamedExec(`INSERT INTO subscriptions (subreddit, type, format_type, guild_id, channel_id, webhook_id, webhook_token, removed_posts, post_kinds, include_patterns, exclude_patterns, flair_allowlist, flair_denylist, allow_nsfw, min_score, min_comments, min_upvote_ratio, time_window, author_allowlist, author_denylist, announcements_only, domain_allowlist, domain_denylist, filter_expression) VALUES (:subreddit, :type, :format_type, :guild_id, :channel_id, :webhook_id, :webhook_token, :removed_posts, :post_kinds, :include_patterns, :exclude_patterns, :flair_allowlist, :flair_denylist, :allow_nsfw, :min_score, :min_comments, :min_upvote_ratio, :time_window, :author_allowlist, :author_denylist, :announcements_only, :domain_allowlist, :domain_denylist, :filter_expression)`, sub)
+	return err
+}
+
+// UpdateSubscription updates the settings of a subscription which can be changed with /reddit update.
+func (d *DB) UpdateSubscription(sub Subscription) error {
+	_, err := d.dbx.NamedExec(`UPDATE subscriptions SET type = :type, format_type = :format_type, removed_posts = :removed_posts, post_kinds = :post_kinds, include_patterns = :include_patterns, exclude_patterns = :exclude_patterns, flair_allowlist = :flair_allowlist, flair_denylist = :flair_denylist, allow_nsfw = :allow_nsfw, min_score = :min_score, min_comments = :min_comments, min_upvote_ratio = :min_upvote_ratio, time_window = :time_window, author_allowlist = :author_allowlist, author_denylist = :author_denylist, announcements_only = :announcements_only, domain_allowlist = :domain_allowlist, domain_denylist = :domain_denylist, filter_expression = :filter_expression WHERE webhook_id = :webhook_id`, sub)
+	return err
+}
+
+func (d *DB) UpdateSubscriptionSchedule(webhookID snowflake.ID, lastCheck time.Time, nextCheck time.Time, postRate float64) error {
+	_, err := d.dbx.Exec(`UPDATE subscriptions SET last_check = $1, next_check = $2, post_rate = $3 WHERE webhook_id = $4`, lastCheck, nextCheck, postRate, webhookID)
 	return err
 }
 
-func (d *DB) UpdateSubscription(webhookID snowflake.ID, postType string, formatType FormatType) error {
-	_, err := d.dbx.Exec(`UPDATE subscriptions SET type = $1, format_type = $2 WHERE webhook_id = $3`, postType, formatType, webhookID)
+func (d *DB) UpdateSubscriptionSuspension(webhookID snowflake.ID, suspended bool, suspendedSince time.Time, failures int, lastError string) error {
+	_, err := d.dbx.Exec(`UPDATE subscriptions SET suspended = $1, suspended_since = $2, failures = $3, last_error = $4 WHERE webhook_id = $5`, suspended, suspendedSince, failures, lastError, webhookID)
 	return err
 }
 
+// UpdateSubscriptionLastPost moves the last post of a subscription forward, it never goes back to an older post.
 func (d *DB) UpdateSubscriptionLastPost(webhookID snowflake.ID, lastPost time.Time) error {
-	_, err := d.dbx.Exec(`UPDATE subscriptions SET last_post = $1 WHERE webhook_id = $2`, lastPost, webhookID)
+	_, err := d.dbx.Exec(`UPDATE subscriptions SET last_post = $1 WHERE webhook_id = $2 AND last_post < $1`, lastPost, webhookID)
 	return err
 }
 
@@ -93,6 +225,16 @@ func (d *DB) RemoveSubscription(webhookID snowflake.ID) (*Subscription, error) {
 		return nil, err
 	}
 
+	if err := d.RemoveSeenPosts(sub.WebhookID); err != nil {
+		return nil, err
+	}
+	if err := d.RemoveOutboxEntries(sub.WebhookID); err != nil {
+		return nil, err
+	}
+	if err := d.RemoveDeliveredMessages(sub.WebhookID); err != nil {
+		return nil, err
+	}
+
 	return &sub, nil
 }
 
@@ -105,13 +247,108 @@ func (d *DB) RemoveSubscriptionByGuildSubreddit(guildID snowflake.ID, subreddit
 		return nil, err
 	}
 
+	if err := d.RemoveSeenPosts(sub.WebhookID); err != nil {
+		return nil, err
+	}
+	if err := d.RemoveOutboxEntries(sub.WebhookID); err != nil {
+		return nil, err
+	}
+	if err := d.RemoveDeliveredMessages(sub.WebhookID); err != nil {
+		return nil, err
+	}
+
 	return &sub, nil
 }
 
-func (d *DB) GetAllSubscriptionIDs() ([]snowflake.ID, error) {
-	var ids []snowflake.ID
-	err := d.dbx.Select(&ids, `SELECT webhook_id FROM subscriptions`)
-	return ids, err
+// GetLeasedSubscriptions returns the subscriptions a replica holds an unexpired lease on.
+func (d *DB) GetLeasedSubscriptions(owner string, now time.Time) ([]Subscription, error) {
+	var subs []Subscription
+	err := d.dbx.Select(&subs, `SELECT * FROM subscriptions WHERE lease_owner = $1 AND lease_expires > $2`, owner, now)
+	return subs, err
+}
+
+// listingColumns groups subscriptions by the subreddit listing they share like listingKey, leases are always moved per listing
+// so a listing is only fetched by one replica.
+const listingColumns = `LOWER(subreddit), type, CASE WHEN type IN ('top', 'controversial') THEN time_window ELSE '' END`
+
+// CountListings returns the number of distinct subreddit listings of all subscriptions.
+func (d *DB) CountListings() (int, error) {
+	var count int
+	err := d.dbx.Get(&count, `SELECT COUNT(*) FROM (SELECT DISTINCT `+listingColumns+` FROM subscriptions) l`)
+	return count, err
+}
+
+// RenewLeases extends the unexpired leases of a replica and returns how many listings it holds.
+// New subscriptions of a listing the replica holds are leased to it too.
+func (d *DB) RenewLeases(owner string, now time.Time, expires time.Time) (int, error) {
+	if _, err := d.dbx.Exec(`UPDATE subscriptions SET lease_expires = $1 WHERE lease_owner = $2 AND lease_expires > $3`, expires, owner, now); err != nil {
+		return 0, err
+	}
+	if _, err := d.dbx.Exec(`UPDATE subscriptions SET lease_owner = $1, lease_expires = $2 WHERE (lease_owner = '' OR lease_expires <= $3) AND (`+listingColumns+`) IN (
+		SELECT `+listingColumns+` FROM subscriptions WHERE lease_owner = $1 AND lease_expires > $3
+	)`, owner, expires, now); err != nil {
+		return 0, err
+	}
+
+	var count int
+	err := d.dbx.Get(&count, `SELECT COUNT(*) FROM (SELECT DISTINCT `+listingColumns+` FROM subscriptions WHERE lease_owner = $1 AND lease_expires > $2) l`, owner, now)
+	return count, err
+}
+
+// ClaimLeases leases up to limit listings whose subscriptions all have no owner or an expired lease to a replica and returns how many subscriptions it got.
+// The condition is checked again for every row, so when replicas claim at the same time each subscription only goes to one of them.
+func (d *DB) ClaimLeases(owner string, now time.Time, expires time.Time, limit int) (int64, error) {
+	rs, err := d.dbx.Exec(`UPDATE subscriptions SET lease_owner = $1, lease_expires = $2 WHERE (lease_owner = '' OR lease_expires <= $3) AND (`+listingColumns+`) IN (
+		SELECT `+listingColumns+` FROM subscriptions GROUP BY `+listingColumns+`
+		HAVING SUM(CASE WHEN lease_owner <> '' AND lease_expires > $3 THEN 1 ELSE 0 END) = 0
+		ORDER BY MIN(LOWER(subreddit)) LIMIT $4
+	)`, owner, expires, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
+
+// ReleaseLeases gives the leases of up to limit listings of a replica back so other replicas can claim them.
+// Listings with posts in the outbox are kept until they are delivered, so no post is delivered by two replicas.
+func (d *DB) ReleaseLeases(owner string, limit int) (int64, error) {
+	rs, err := d.dbx.Exec(`UPDATE subscriptions SET lease_owner = '' WHERE lease_owner = $1 AND (`+listingColumns+`) IN (
+		SELECT `+listingColumns+` FROM subscriptions s WHERE s.lease_owner = $1 GROUP BY `+listingColumns+`
+		HAVING SUM(CASE WHEN EXISTS (SELECT 1 FROM outbox o WHERE o.webhook_id = s.webhook_id) THEN 1 ELSE 0 END) = 0
+		ORDER BY MIN(LOWER(subreddit)) DESC LIMIT $2
+	)`, owner, limit)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
+
+func (d *DB) ReleaseAllLeases(owner string) error {
+	_, err := d.dbx.Exec(`UPDATE subscriptions SET lease_owner = '' WHERE lease_owner = $1`, owner)
+	return err
+}
+
+// UpdateReplica marks a replica as alive.
+func (d *DB) UpdateReplica(id string, lastSeen time.Time) error {
+	_, err := d.dbx.Exec(`INSERT INTO replicas (id, last_seen) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET last_seen = excluded.last_seen`, id, lastSeen)
+	return err
+}
+
+// CountReplicas returns the number of replicas which were alive since the given time.
+func (d *DB) CountReplicas(since time.Time) (int, error) {
+	var count int
+	err := d.dbx.Get(&count, `SELECT COUNT(*) FROM replicas WHERE last_seen > $1`, since)
+	return count, err
+}
+
+func (d *DB) RemoveReplica(id string) error {
+	_, err := d.dbx.Exec(`DELETE FROM replicas WHERE id = $1`, id)
+	return err
+}
+
+func (d *DB) RemoveReplicasBefore(before time.Time) error {
+	_, err := d.dbx.Exec(`DELETE FROM replicas WHERE last_seen < $1`, before)
+	return err
 }
 
 func (d *DB) HasSubscription(webhookID snowflake.ID) (bool, error) {
@@ -161,3 +398,173 @@ func (d *DB) GetSubscriptionsByGuildSubreddit(guildID snowflake.ID, subreddit st
 
 	return &sub, nil
 }
+
+// GetSeenPosts returns the names of the posts which were delivered to a webhook after since or are waiting in its outbox.
+func (d *DB) GetSeenPosts(webhookID snowflake.ID, since time.Time) (map[string]struct{}, error) {
+	var names []string
+	if err := d.dbx.Select(&names, `SELECT name FROM seen_posts WHERE webhook_id = $1 AND seen_at > $2 UNION SELECT post_name FROM outbox WHERE webhook_id = $1`, webhookID, since); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+	return seen, nil
+}
+
+func (d *DB) AddSeenPost(webhookID snowflake.ID, name string) error {
+	_, err := d.dbx.Exec(`INSERT INTO seen_posts (webhook_id, name, seen_at) VALUES ($1, $2, $3) ON CONFLICT (webhook_id, name) DO NOTHING`, webhookID, name, time.Now())
+	return err
+}
+
+func (d *DB) RemoveSeenPosts(webhookID snowflake.ID) error {
+	_, err := d.dbx.Exec(`DELETE FROM seen_posts WHERE webhook_id = $1`, webhookID)
+	return err
+}
+
+// RemoveSeenPostsBefore removes the posts seen before before, except the ones of top and controversial subscriptions.
+func (d *DB) RemoveSeenPostsBefore(before time.Time) (int64, error) {
+	rs, err := d.dbx.Exec(`DELETE FROM seen_posts WHERE seen_at < $1 AND webhook_id NOT IN (SELECT webhook_id FROM subscriptions WHERE type IN ('top', 'controversial'))`, before)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
+
+// RemoveTimeWindowSeenPostsBefore removes the posts seen before before by top and controversial subscriptions with the given time window.
+func (d *DB) RemoveTimeWindowSeenPostsBefore(timeWindow string, before time.Time) (int64, error) {
+	rs, err := d.dbx.Exec(`DELETE FROM seen_posts WHERE seen_at < $1 AND webhook_id IN (SELECT webhook_id FROM subscriptions WHERE type IN ('top', 'controversial') AND time_window = $2)`, before, timeWindow)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
+
+// AddOutboxEntry adds a post to the outbox of a webhook leased by owner, posts which were already delivered are ignored.
+// It fails with ErrLeaseLost when another replica took over the subscription in the meantime.
+func (d *DB) AddOutboxEntry(owner string, entry OutboxEntry) error {
+	tx, err := d.dbx.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var leased int
+	if err = tx.Get(&leased, `SELECT COUNT(*) FROM subscriptions WHERE webhook_id = $1 AND lease_owner = $2 AND lease_expires > $3`, entry.WebhookID, owner, entry.CreatedAt); err != nil {
+		return err
+	}
+	if leased == 0 {
+		return ErrLeaseLost
+	}
+	var seen int
+	if err = tx.Get(&seen, `SELECT COUNT(*) FROM seen_posts WHERE webhook_id = $1 AND name = $2`, entry.WebhookID, entry.PostName); err != nil {
+		return err
+	}
+	if seen > 0 {
+		return nil
+	}
+
+	if _, err = tx.NamedExec(`INSERT INTO outbox (webhook_id, post_name, post_created, payload, attempts, next_attempt, last_error, created_at) VALUES (:webhook_id, :post_name, :post_created, :payload, :attempts, :next_attempt, :last_error, :created_at) ON CONFLICT (webhook_id, post_name) DO NOTHING`, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDueOutboxEntries returns the oldest entry of each webhook leased by owner if it's due to be delivered.
+// Later entries of a webhook wait for the ones before them, so posts are delivered in order.
+func (d *DB) GetDueOutboxEntries(owner string, now time.Time, limit int) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := d.dbx.Select(&entries, `SELECT * FROM outbox o WHERE o.next_attempt <= $1 AND NOT EXISTS (
+		SELECT 1 FROM outbox p WHERE p.webhook_id = o.webhook_id AND (p.created_at < o.created_at OR (p.created_at = o.created_at AND p.post_name < o.post_name))
+	) AND EXISTS (
+		SELECT 1 FROM subscriptions s WHERE s.webhook_id = o.webhook_id AND s.lease_owner = $2 AND s.lease_expires > $1
+	) ORDER BY o.next_attempt LIMIT $3`, now, owner, limit)
+	return entries, err
+}
+
+// GetOutboxEntry returns the current state of an outbox entry, it fails with ErrOutboxEntryNotFound once the entry was delivered.
+func (d *DB) GetOutboxEntry(webhookID snowflake.ID, postName string) (*OutboxEntry, error) {
+	var entry OutboxEntry
+	if err := d.dbx.Get(&entry, `SELECT * FROM outbox WHERE webhook_id = $1 AND post_name = $2`, webhookID, postName); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOutboxEntryNotFound
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (d *DB) UpdateOutboxEntryAttempt(webhookID snowflake.ID, postName string, attempts int, nextAttempt time.Time, lastError string) error {
+	_, err := d.dbx.Exec(`UPDATE outbox SET attempts = $1, next_attempt = $2, last_error = $3 WHERE webhook_id = $4 AND post_name = $5`, attempts, nextAttempt, lastError, webhookID, postName)
+	return err
+}
+
+func (d *DB) RemoveOutboxEntry(webhookID snowflake.ID, postName string) error {
+	_, err := d.dbx.Exec(`DELETE FROM outbox WHERE webhook_id = $1 AND post_name = $2`, webhookID, postName)
+	return err
+}
+
+func (d *DB) RemoveOutboxEntries(webhookID snowflake.ID) error {
+	_, err := d.dbx.Exec(`DELETE FROM outbox WHERE webhook_id = $1`, webhookID)
+	return err
+}
+
+// MoveOutboxEntryToDeadLetters removes an entry which can't be delivered from the outbox and keeps it in the dead letters.
+func (d *DB) MoveOutboxEntryToDeadLetters(entry OutboxEntry, lastError string) error {
+	tx, err := d.dbx.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`INSERT INTO dead_letters (webhook_id, post_name, post_created, payload, attempts, last_error, created_at, failed_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (webhook_id, post_name) DO NOTHING`,
+		entry.WebhookID, entry.PostName, entry.PostCreated, entry.Payload, entry.Attempts, lastError, entry.CreatedAt, time.Now(),
+	); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM outbox WHERE webhook_id = $1 AND post_name = $2`, entry.WebhookID, entry.PostName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) AddDeliveredMessage(message DeliveredMessage) error {
+	_, err := d.dbx.NamedExec(`INSERT INTO messages (webhook_id, post_name, message_id, payload, removed, delivered_at) VALUES (:webhook_id, :post_name, :message_id, :payload, :removed, :delivered_at) ON CONFLICT (webhook_id, post_name) DO NOTHING`, message)
+	return err
+}
+
+// GetRefreshableMessages returns the messages of subscriptions leased by owner which were delivered after since and were not removed yet, newest first.
+func (d *DB) GetRefreshableMessages(owner string, now time.Time, since time.Time) ([]DeliveredMessage, error) {
+	var messages []DeliveredMessage
+	err := d.dbx.Select(&messages, `SELECT m.* FROM messages m JOIN subscriptions s ON s.webhook_id = m.webhook_id WHERE s.lease_owner = $1 AND s.lease_expires > $2 AND m.delivered_at > $3 AND m.removed = FALSE ORDER BY m.delivered_at DESC`, owner, now, since)
+	return messages, err
+}
+
+func (d *DB) UpdateDeliveredMessagePayload(webhookID snowflake.ID, postName string, payload string) error {
+	_, err := d.dbx.Exec(`UPDATE messages SET payload = $1 WHERE webhook_id = $2 AND post_name = $3`, payload, webhookID, postName)
+	return err
+}
+
+func (d *DB) MarkDeliveredMessageRemoved(webhookID snowflake.ID, postName string) error {
+	_, err := d.dbx.Exec(`UPDATE messages SET removed = TRUE WHERE webhook_id = $1 AND post_name = $2`, webhookID, postName)
+	return err
+}
+
+func (d *DB) RemoveDeliveredMessage(webhookID snowflake.ID, postName string) error {
+	_, err := d.dbx.Exec(`DELETE FROM messages WHERE webhook_id = $1 AND post_name = $2`, webhookID, postName)
+	return err
+}
+
+func (d *DB) RemoveDeliveredMessages(webhookID snowflake.ID) error {
+	_, err := d.dbx.Exec(`DELETE FROM messages WHERE webhook_id = $1`, webhookID)
+	return err
+}
+
+func (d *DB) RemoveDeliveredMessagesBefore(before time.Time) (int64, error) {
+	rs, err := d.dbx.Exec(`DELETE FROM messages WHERE delivered_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return rs.RowsAffected()
+}
@@ -1,14 +1,17 @@
 package redditbot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/json"
+	"github.com/disgoorg/log"
 	"github.com/disgoorg/snowflake/v2"
 )
 
@@ -29,6 +32,37 @@ var typeChoices = []discord.ApplicationCommandOptionChoiceString{
 		Name:  "Rising",
 		Value: "rising",
 	},
+	{
+		Name:  "Controversial",
+		Value: "controversial",
+	},
+}
+
+var timeWindowChoices = []discord.ApplicationCommandOptionChoiceString{
+	{
+		Name:  "Past Hour",
+		Value: "hour",
+	},
+	{
+		Name:  "Today",
+		Value: "day",
+	},
+	{
+		Name:  "This Week",
+		Value: "week",
+	},
+	{
+		Name:  "This Month",
+		Value: "month",
+	},
+	{
+		Name:  "This Year",
+		Value: "year",
+	},
+	{
+		Name:  "All Time",
+		Value: "all",
+	},
 }
 
 var formatTypeChoices = []discord.ApplicationCommandOptionChoiceString{
@@ -42,6 +76,17 @@ var formatTypeChoices = []discord.ApplicationCommandOptionChoiceString{
 	},
 }
 
+var removedPostsChoices = []discord.ApplicationCommandOptionChoiceString{
+	{
+		Name:  "Mark as removed",
+		Value: string(RemovedPostActionMark),
+	},
+	{
+		Name:  "Delete",
+		Value: string(RemovedPostActionDelete),
+	},
+}
+
 var Commands = []discord.ApplicationCommandCreate{
 	discord.SlashCommandCreate{
 		Name:        "reddit",
@@ -62,12 +107,106 @@ var Commands = []discord.ApplicationCommandCreate{
 						Required:    false,
 						Choices:     typeChoices,
 					},
+					discord.ApplicationCommandOptionString{
+						Name:        "time-window",
+						Description: "the time window of top and controversial posts",
+						Required:    false,
+						Choices:     timeWindowChoices,
+					},
 					discord.ApplicationCommandOptionString{
 						Name:        "format-type",
 						Description: "how to format the subreddit posts",
 						Required:    false,
 						Choices:     formatTypeChoices,
 					},
+					discord.ApplicationCommandOptionString{
+						Name:        "removed-posts",
+						Description: "what to do with posts which are removed from reddit",
+						Required:    false,
+						Choices:     removedPostsChoices,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "kinds",
+						Description: "comma separated kinds of posts to send: self, link, image, gallery, video, poll, crosspost or all",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "include",
+						Description: "comma separated words or /regex/ posts have to contain one of, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "exclude",
+						Description: "comma separated words or /regex/ posts must not contain, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:         "allow-flairs",
+						Description:  "comma separated flairs posts need to have one of, none removes them",
+						Required:     false,
+						Autocomplete: true,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:         "deny-flairs",
+						Description:  "comma separated flairs posts must not have, none removes them",
+						Required:     false,
+						Autocomplete: true,
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "allow-nsfw",
+						Description: "whether to send nsfw posts, they are only sent to age-restricted channels",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "allow-authors",
+						Description: "comma separated users posts need to be from one of, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "deny-authors",
+						Description: "comma separated users whose posts are not sent, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "announcements-only",
+						Description: "whether to only send posts distinguished or stickied by the moderators",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "allow-domains",
+						Description: "comma separated domains like *.example.com links need to be to, +/- edits them, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "deny-domains",
+						Description: "comma separated domains like *.example.com links must not be to, +/- edits them, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "filter",
+						Description: "an expression posts have to match like score > 100 && flair != 'Meme', none removes it",
+						Required:    false,
+						MaxLength:   json.Ptr(maxExpressionLength),
+					},
+					discord.ApplicationCommandOptionInt{
+						Name:        "min-score",
+						Description: "the score posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0),
+					},
+					discord.ApplicationCommandOptionInt{
+						Name:        "min-comments",
+						Description: "the comments posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0),
+					},
+					discord.ApplicationCommandOptionFloat{
+						Name:        "min-upvote-ratio",
+						Description: "the upvote ratio from 0 to 1 posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0.0),
+						MaxValue:    json.Ptr(1.0),
+					},
 				},
 			},
 			discord.ApplicationCommandOptionSubCommand{
@@ -85,12 +224,106 @@ var Commands = []discord.ApplicationCommandCreate{
 						Required:    false,
 						Choices:     typeChoices,
 					},
+					discord.ApplicationCommandOptionString{
+						Name:        "time-window",
+						Description: "the time window of top and controversial posts",
+						Required:    false,
+						Choices:     timeWindowChoices,
+					},
 					discord.ApplicationCommandOptionString{
 						Name:        "format-type",
 						Description: "how to format the subreddit posts",
 						Required:    false,
 						Choices:     formatTypeChoices,
 					},
+					discord.ApplicationCommandOptionString{
+						Name:        "removed-posts",
+						Description: "what to do with posts which are removed from reddit",
+						Required:    false,
+						Choices:     removedPostsChoices,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "kinds",
+						Description: "comma separated kinds of posts to send: self, link, image, gallery, video, poll, crosspost or all",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "include",
+						Description: "comma separated words or /regex/ posts have to contain one of, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "exclude",
+						Description: "comma separated words or /regex/ posts must not contain, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:         "allow-flairs",
+						Description:  "comma separated flairs posts need to have one of, none removes them",
+						Required:     false,
+						Autocomplete: true,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:         "deny-flairs",
+						Description:  "comma separated flairs posts must not have, none removes them",
+						Required:     false,
+						Autocomplete: true,
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "allow-nsfw",
+						Description: "whether to send nsfw posts, they are only sent to age-restricted channels",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "allow-authors",
+						Description: "comma separated users posts need to be from one of, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "deny-authors",
+						Description: "comma separated users whose posts are not sent, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionBool{
+						Name:        "announcements-only",
+						Description: "whether to only send posts distinguished or stickied by the moderators",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "allow-domains",
+						Description: "comma separated domains like *.example.com links need to be to, +/- edits them, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "deny-domains",
+						Description: "comma separated domains like *.example.com links must not be to, +/- edits them, none removes them",
+						Required:    false,
+					},
+					discord.ApplicationCommandOptionString{
+						Name:        "filter",
+						Description: "an expression posts have to match like score > 100 && flair != 'Meme', none removes it",
+						Required:    false,
+						MaxLength:   json.Ptr(maxExpressionLength),
+					},
+					discord.ApplicationCommandOptionInt{
+						Name:        "min-score",
+						Description: "the score posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0),
+					},
+					discord.ApplicationCommandOptionInt{
+						Name:        "min-comments",
+						Description: "the comments posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0),
+					},
+					discord.ApplicationCommandOptionFloat{
+						Name:        "min-upvote-ratio",
+						Description: "the upvote ratio from 0 to 1 posts need before they are sent, not for new posts, 0 removes it",
+						Required:    false,
+						MinValue:    json.Ptr(0.0),
+						MaxValue:    json.Ptr(1.0),
+					},
 				},
 			},
 			discord.ApplicationCommandOptionSubCommand{
@@ -104,6 +337,40 @@ var Commands = []discord.ApplicationCommandCreate{
 					},
 				},
 			},
+			discord.ApplicationCommandOptionSubCommand{
+				Name:        "filters",
+				Description: "show the active filters of your subscribed subreddits",
+				Options: []discord.ApplicationCommandOption{
+					discord.ApplicationCommandOptionString{
+						Name:        "subreddit",
+						Description: "the subreddit to show the filters for",
+						Required:    false,
+					},
+				},
+			},
+			discord.ApplicationCommandOptionSubCommandGroup{
+				Name:        "filter",
+				Description: "work with filter expressions",
+				Options: []discord.ApplicationCommandOptionSubCommand{
+					{
+						Name:        "test",
+						Description: "show which of the current posts of a subreddit a filter expression lets through",
+						Options: []discord.ApplicationCommandOption{
+							discord.ApplicationCommandOptionString{
+								Name:        "subreddit",
+								Description: "the subreddit to test the expression on",
+								Required:    true,
+							},
+							discord.ApplicationCommandOptionString{
+								Name:        "expression",
+								Description: "the expression to test, defaults to the one of your subscription",
+								Required:    false,
+								MaxLength:   json.Ptr(maxExpressionLength),
+							},
+						},
+					},
+				},
+			},
 			discord.ApplicationCommandOptionSubCommand{
 				Name:        "list",
 				Description: "list your subscribed subreddits",
@@ -128,6 +395,12 @@ func (b *Bot) OnApplicationCommand(event *events.ApplicationCommandInteractionCr
 	data := event.SlashCommandInteractionData()
 	switch data.CommandName() {
 	case "reddit":
+		if data.SubCommandGroupName != nil {
+			if *data.SubCommandGroupName == "filter" && *data.SubCommandName == "test" {
+				b.OnFilterTest(data, event)
+			}
+			return
+		}
 		switch *data.SubCommandName {
 		case "add":
 			b.OnSubredditAdd(data, event)
@@ -137,24 +410,167 @@ func (b *Bot) OnApplicationCommand(event *events.ApplicationCommandInteractionCr
 			b.OnSubredditRemove(data, event)
 		case "list":
 			b.OnSubredditList(data, event)
+		case "filters":
+			b.OnSubredditFilters(data, event)
 		}
 	case "info":
 		b.OnInfo(event)
 	}
 }
 
+func (b *Bot) OnAutocomplete(event *events.AutocompleteInteractionCreate) {
+	if event.Data.CommandName != "reddit" {
+		return
+	}
+	option, ok := event.Data.Find(func(option discord.AutocompleteOption) bool {
+		return option.Focused
+	})
+	if !ok {
+		return
+	}
+
+	switch option.Name {
+	case "allow-flairs", "deny-flairs":
+		b.OnFlairAutocomplete(event, option.Name)
+	}
+}
+
+// OnFlairAutocomplete suggests flairs from the latest posts of the subreddit for the last flair of the comma separated list.
+func (b *Bot) OnFlairAutocomplete(event *events.AutocompleteInteractionCreate, optionName string) {
+	subreddit := event.Data.String("subreddit")
+	if subreddit == "" {
+		_ = event.Result([]discord.AutocompleteChoice{})
+		return
+	}
+
+	value := event.Data.String(optionName)
+	var prefix string
+	current := value
+	if i := strings.LastIndex(value, ","); i != -1 {
+		prefix = strings.TrimSpace(value[:i]) + ", "
+		current = value[i+1:]
+	}
+	current = strings.ToLower(strings.TrimSpace(current))
+
+	// leave enough time to respond before the interaction expires
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	flairs, err := b.recentFlairs(ctx, subreddit)
+	if err != nil {
+		_ = event.Result([]discord.AutocompleteChoice{})
+		return
+	}
+
+	choices := []discord.AutocompleteChoice{}
+	for _, flair := range flairs {
+		if !strings.Contains(strings.ToLower(flair), current) || containsName(prefix, flair) {
+			continue
+		}
+		choice := prefix + flair
+		if len(choice) > 100 {
+			continue
+		}
+		choices = append(choices, discord.AutocompleteChoiceString{
+			Name:  choice,
+			Value: choice,
+		})
+		if len(choices) == 25 {
+			break
+		}
+	}
+	_ = event.Result(choices)
+}
+
 func (b *Bot) OnSubredditAdd(data discord.SlashCommandInteractionData, event *events.ApplicationCommandInteractionCreate) {
 	subreddit := data.String("subreddit")
 	postType, ok := data.OptString("type")
 	if !ok {
 		postType = "new"
 	}
+	timeWindow, ok := data.OptString("time-window")
+	if !ok {
+		timeWindow = "day"
+	}
 	formatType, ok := data.OptString("format-type")
 	if !ok {
 		formatType = "embed"
 	}
+	removedPosts, ok := data.OptString("removed-posts")
+	if !ok {
+		removedPosts = string(RemovedPostActionMark)
+	}
+	postKinds, err := parsePostKinds(data.String("kinds"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid kinds: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	includePatterns, err := parsePatterns(data.String("include"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid include filter: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	excludePatterns, err := parsePatterns(data.String("exclude"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid exclude filter: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	flairAllowlist := parseFlairs(data.String("allow-flairs"))
+	flairDenylist := parseFlairs(data.String("deny-flairs"))
+	allowNSFW, ok := data.OptBool("allow-nsfw")
+	if !ok {
+		allowNSFW = true
+	}
+	authorAllowlist := parseAuthors(data.String("allow-authors"))
+	authorDenylist := parseAuthors(data.String("deny-authors"))
+	announcementsOnly := data.Bool("announcements-only")
+	domainAllowlist, err := editDomains("", data.String("allow-domains"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid allowed domains: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	domainDenylist, err := editDomains("", data.String("deny-domains"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid denied domains: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	filterExpression, err := parseExpression(data.String("filter"))
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid filter: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+	thresholds := Subscription{
+		Type:           postType,
+		MinScore:       data.Int("min-score"),
+		MinComments:    data.Int("min-comments"),
+		MinUpvoteRatio: data.Float("min-upvote-ratio"),
+	}
+	if err = thresholds.validateThresholds(); err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid thresholds: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
 
-	ok, err := b.DB.HasSubscriptionByGuildSubreddit(*event.GuildID(), subreddit)
+	ok, err = b.DB.HasSubscriptionByGuildSubreddit(*event.GuildID(), subreddit)
 	if err != nil {
 		_ = event.CreateMessage(discord.MessageCreate{
 			Content: "Failed to check if you are already subscribed to this subreddit: " + err.Error(),
@@ -170,7 +586,11 @@ func (b *Bot) OnSubredditAdd(data discord.SlashCommandInteractionData, event *ev
 		return
 	}
 
-	if err = b.Reddit.CheckSubreddit(subreddit); err != nil {
+	// leave enough time to respond before the interaction expires
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	about, err := b.Reddit.CheckSubreddit(ctx, subreddit)
+	if err != nil {
 		_ = event.CreateMessage(discord.MessageCreate{
 			Content: "Invalid subreddit: " + err.Error(),
 			Flags:   discord.MessageFlagEphemeral,
@@ -178,18 +598,40 @@ func (b *Bot) OnSubredditAdd(data discord.SlashCommandInteractionData, event *ev
 		return
 	}
 
+	var warning string
+	if about.Over18 && !isNSFWChannel(event.Channel().MessageChannel) {
+		warning = fmt.Sprintf("\n**Warning:** r/%s is marked as NSFW but this channel is not age-restricted, NSFW posts will be skipped", subreddit)
+	}
+
 	if b.Cfg.Server.Enabled {
 		state := b.randomString(16)
 		url := b.DiscordConfig.AuthCodeURL(state)
 
 		b.States[state] = SetupState{
-			Subreddit:   subreddit,
-			PostType:    postType,
-			FormatType:  FormatType(formatType),
-			Interaction: event.ApplicationCommandInteraction,
+			Subreddit:         subreddit,
+			PostType:          postType,
+			TimeWindow:        timeWindow,
+			FormatType:        FormatType(formatType),
+			RemovedPosts:      RemovedPostAction(removedPosts),
+			PostKinds:         postKinds,
+			IncludePatterns:   includePatterns,
+			ExcludePatterns:   excludePatterns,
+			FlairAllowlist:    flairAllowlist,
+			FlairDenylist:     flairDenylist,
+			AllowNSFW:         allowNSFW,
+			AuthorAllowlist:   authorAllowlist,
+			AuthorDenylist:    authorDenylist,
+			AnnouncementsOnly: announcementsOnly,
+			DomainAllowlist:   domainAllowlist,
+			DomainDenylist:    domainDenylist,
+			FilterExpression:  filterExpression,
+			MinScore:          thresholds.MinScore,
+			MinComments:       thresholds.MinComments,
+			MinUpvoteRatio:    thresholds.MinUpvoteRatio,
+			Interaction:       event.ApplicationCommandInteraction,
 		}
 		_ = event.CreateMessage(discord.MessageCreate{
-			Content: fmt.Sprintf("Click the button to add a webhook for the subreddit %s", subreddit),
+			Content: fmt.Sprintf("Click the button to add a webhook for the subreddit %s", subreddit) + warning,
 			Components: []discord.ContainerComponent{
 				discord.ActionRowComponent{
 					discord.NewLinkButton("Add Webhook", url),
@@ -223,13 +665,30 @@ func (b *Bot) OnSubredditAdd(data discord.SlashCommandInteractionData, event *ev
 	}
 
 	if err = b.DB.AddSubscription(Subscription{
-		Subreddit:    subreddit,
-		Type:         postType,
-		FormatType:   FormatType(formatType),
-		GuildID:      *event.GuildID(),
-		ChannelID:    event.Channel().ID(),
-		WebhookID:    webhook.ID(),
-		WebhookToken: webhook.Token,
+		Subreddit:         subreddit,
+		Type:              postType,
+		TimeWindow:        timeWindow,
+		FormatType:        FormatType(formatType),
+		GuildID:           *event.GuildID(),
+		ChannelID:         event.Channel().ID(),
+		WebhookID:         webhook.ID(),
+		WebhookToken:      webhook.Token,
+		RemovedPosts:      RemovedPostAction(removedPosts),
+		PostKinds:         postKinds,
+		IncludePatterns:   includePatterns,
+		ExcludePatterns:   excludePatterns,
+		FlairAllowlist:    flairAllowlist,
+		FlairDenylist:     flairDenylist,
+		AllowNSFW:         allowNSFW,
+		AuthorAllowlist:   authorAllowlist,
+		AuthorDenylist:    authorDenylist,
+		AnnouncementsOnly: announcementsOnly,
+		DomainAllowlist:   domainAllowlist,
+		DomainDenylist:    domainDenylist,
+		FilterExpression:  filterExpression,
+		MinScore:          thresholds.MinScore,
+		MinComments:       thresholds.MinComments,
+		MinUpvoteRatio:    thresholds.MinUpvoteRatio,
 	}); err != nil {
 		_ = event.CreateMessage(discord.MessageCreate{
 			Content: "Failed to save subscription to the database: " + err.Error(),
@@ -239,14 +698,16 @@ func (b *Bot) OnSubredditAdd(data discord.SlashCommandInteractionData, event *ev
 	}
 
 	_ = event.CreateMessage(discord.MessageCreate{
-		Content: fmt.Sprintf("Subscribed to [r/%s](<https://reddit.com/r/%s>)", subreddit, subreddit),
+		Content: fmt.Sprintf("Subscribed to [r/%s](<https://reddit.com/r/%s>)", subreddit, subreddit) + warning,
 	})
 }
 
 func (b *Bot) OnSubredditUpdate(data discord.SlashCommandInteractionData, event *events.ApplicationCommandInteractionCreate) {
 	subreddit := data.String("subreddit")
 	postType := data.String("type")
+	timeWindow := data.String("time-window")
 	formatType := FormatType(data.String("format-type"))
+	removedPosts := RemovedPostAction(data.String("removed-posts"))
 
 	sub, err := b.DB.GetSubscriptionsByGuildSubreddit(*event.GuildID(), subreddit)
 	if err == ErrSubscriptionNotFound {
@@ -264,20 +725,125 @@ func (b *Bot) OnSubredditUpdate(data discord.SlashCommandInteractionData, event
 		return
 	}
 
-	if postType == "" {
-		postType = sub.Type
+	// a new listing starts at the current time, so it doesn't send the posts it already has
+	listingChanged := (postType != "" && postType != sub.Type) || (timeWindow != "" && timeWindow != sub.TimeWindow && hasTimeWindow(sub.Type))
+	if postType != "" {
+		sub.Type = postType
+	}
+	if timeWindow != "" {
+		sub.TimeWindow = timeWindow
+	}
+	if formatType != "" {
+		sub.FormatType = formatType
+	}
+	if removedPosts != "" {
+		sub.RemovedPosts = removedPosts
+	}
+	if kinds, ok := data.OptString("kinds"); ok {
+		if sub.PostKinds, err = parsePostKinds(kinds); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid kinds: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if include, ok := data.OptString("include"); ok {
+		if sub.IncludePatterns, err = parsePatterns(include); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid include filter: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if exclude, ok := data.OptString("exclude"); ok {
+		if sub.ExcludePatterns, err = parsePatterns(exclude); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid exclude filter: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
 	}
-	if formatType == "" {
-		formatType = sub.FormatType
+	if flairs, ok := data.OptString("allow-flairs"); ok {
+		sub.FlairAllowlist = parseFlairs(flairs)
+	}
+	if flairs, ok := data.OptString("deny-flairs"); ok {
+		sub.FlairDenylist = parseFlairs(flairs)
+	}
+	if allowNSFW, ok := data.OptBool("allow-nsfw"); ok {
+		sub.AllowNSFW = allowNSFW
+	}
+	if authors, ok := data.OptString("allow-authors"); ok {
+		sub.AuthorAllowlist = parseAuthors(authors)
+	}
+	if authors, ok := data.OptString("deny-authors"); ok {
+		sub.AuthorDenylist = parseAuthors(authors)
+	}
+	if announcementsOnly, ok := data.OptBool("announcements-only"); ok {
+		sub.AnnouncementsOnly = announcementsOnly
+	}
+	if domains, ok := data.OptString("allow-domains"); ok {
+		if sub.DomainAllowlist, err = editDomains(sub.DomainAllowlist, domains); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid allowed domains: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if domains, ok := data.OptString("deny-domains"); ok {
+		if sub.DomainDenylist, err = editDomains(sub.DomainDenylist, domains); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid denied domains: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if filter, ok := data.OptString("filter"); ok {
+		if sub.FilterExpression, err = parseExpression(filter); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid filter: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if minScore, ok := data.OptInt("min-score"); ok {
+		sub.MinScore = minScore
+	}
+	if minComments, ok := data.OptInt("min-comments"); ok {
+		sub.MinComments = minComments
+	}
+	if minUpvoteRatio, ok := data.OptFloat("min-upvote-ratio"); ok {
+		sub.MinUpvoteRatio = minUpvoteRatio
+	}
+	if err = sub.validateThresholds(); err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Invalid thresholds: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
 	}
 
-	if err = b.DB.UpdateSubscription(sub.WebhookID, postType, formatType); err != nil {
+	if err = b.DB.UpdateSubscription(*sub); err != nil {
 		_ = event.CreateMessage(discord.MessageCreate{
 			Content: "Failed to update subscription: " + err.Error(),
 			Flags:   discord.MessageFlagEphemeral,
 		})
 		return
 	}
+	if listingChanged {
+		if err = b.DB.UpdateSubscriptionLastPost(sub.WebhookID, time.Now()); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Failed to update subscription: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
 
 	_ = event.CreateMessage(discord.MessageCreate{
 		Content: fmt.Sprintf("Updated subscription for [r/%s](https://reddit.com/r/%s)", subreddit, subreddit),
@@ -331,7 +897,21 @@ func (b *Bot) OnSubredditList(data discord.SlashCommandInteractionData, event *e
 
 	content := fmt.Sprintf("# Subscriptions(%d):\n", len(subs))
 	for _, sub := range subs {
-		content += fmt.Sprintf("- `%s` - `%s` - [r/%s](<https://reddit.com/r/%s>)\n", strings.Title(sub.Type), strings.Title(string(sub.FormatType)), sub.Subreddit, sub.Subreddit)
+		postType := strings.Title(sub.Type)
+		if hasTimeWindow(sub.Type) {
+			postType += " (" + timeWindowName(sub.TimeWindow) + ")"
+		}
+		content += fmt.Sprintf("- `%s` - `%s` - [r/%s](<https://reddit.com/r/%s>)", postType, strings.Title(string(sub.FormatType)), sub.Subreddit, sub.Subreddit)
+		if sub.RemovedPosts == RemovedPostActionDelete {
+			content += " - deletes removed posts"
+		}
+		if sub.PostKinds != "" {
+			content += " - only " + strings.ReplaceAll(sub.PostKinds, ",", ", ")
+		}
+		if sub.Suspended {
+			content += fmt.Sprintf(" - suspended since <t:%d:R>: %s", sub.SuspendedSince.Unix(), sub.LastError)
+		}
+		content += "\n"
 	}
 
 	_ = event.CreateMessage(discord.MessageCreate{
@@ -340,6 +920,224 @@ func (b *Bot) OnSubredditList(data discord.SlashCommandInteractionData, event *e
 	})
 }
 
+func (b *Bot) OnSubredditFilters(data discord.SlashCommandInteractionData, event *events.ApplicationCommandInteractionCreate) {
+	var (
+		subs []Subscription
+		err  error
+	)
+	if subreddit, ok := data.OptString("subreddit"); ok {
+		var sub *Subscription
+		if sub, err = b.DB.GetSubscriptionsByGuildSubreddit(*event.GuildID(), subreddit); err == nil {
+			subs = []Subscription{*sub}
+		} else if err == ErrSubscriptionNotFound {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: fmt.Sprintf("You are not subscribed to r/%s", subreddit),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	} else {
+		subs, err = b.DB.GetSubscriptionsByGuild(*event.GuildID())
+	}
+	if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: fmt.Sprintf("Something went wrong: %s", err),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+
+	var content string
+	for _, sub := range subs {
+		filters := subscriptionFilters(sub)
+		if len(filters) == 0 {
+			continue
+		}
+		content += fmt.Sprintf("### [r/%s](<https://reddit.com/r/%s>)\n", sub.Subreddit, sub.Subreddit)
+		for _, filter := range filters {
+			content += "- " + filter + "\n"
+		}
+	}
+	if content == "" {
+		content = "No filters are active"
+	}
+
+	_ = event.CreateMessage(discord.MessageCreate{
+		Content: content,
+		Flags:   discord.MessageFlagEphemeral,
+	})
+}
+
+// maxFilterTestPosts is the max number of posts listed by /reddit filter test
+const maxFilterTestPosts = 10
+
+// OnFilterTest runs a filter expression against the current posts of a subreddit and lists the posts which would be sent.
+// When the guild is subscribed to the subreddit, its listing and other filters are used too.
+// The channel and posts are requested after the response is deferred and the result is sent as follow-up.
+func (b *Bot) OnFilterTest(data discord.SlashCommandInteractionData, event *events.ApplicationCommandInteractionCreate) {
+	subreddit := data.String("subreddit")
+
+	var nsfw bool
+	sub, err := b.DB.GetSubscriptionsByGuildSubreddit(*event.GuildID(), subreddit)
+	if err == ErrSubscriptionNotFound {
+		sub = &Subscription{
+			Subreddit: subreddit,
+			Type:      "new",
+			AllowNSFW: true,
+		}
+		nsfw = isNSFWChannel(event.Channel().MessageChannel)
+	} else if err != nil {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: "Failed to get subscription from the database: " + err.Error(),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+
+	if expression, ok := data.OptString("expression"); ok {
+		if sub.FilterExpression, err = parseExpression(expression); err != nil {
+			_ = event.CreateMessage(discord.MessageCreate{
+				Content: "Invalid filter: " + err.Error(),
+				Flags:   discord.MessageFlagEphemeral,
+			})
+			return
+		}
+	}
+	if sub.FilterExpression == "" {
+		_ = event.CreateMessage(discord.MessageCreate{
+			Content: fmt.Sprintf("There is no filter for r/%s, pass an expression to test", subreddit),
+			Flags:   discord.MessageFlagEphemeral,
+		})
+		return
+	}
+
+	if err = event.DeferCreateMessage(true); err != nil {
+		log.Errorf("error deferring filter test of r/%s: %s", subreddit, err.Error())
+		return
+	}
+	followup := func(messageCreate discord.MessageCreate) {
+		messageCreate.Flags = discord.MessageFlagEphemeral
+		if _, err := b.Client.Rest().CreateFollowupMessage(event.ApplicationID(), event.Token(), messageCreate); err != nil {
+			log.Errorf("error sending filter test of r/%s: %s", subreddit, err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if sub.WebhookID != 0 && sub.AllowNSFW {
+		if nsfw, err = b.isChannelNSFW(ctx, sub.ChannelID); err != nil {
+			followup(discord.MessageCreate{
+				Content: "Failed to get the channel of the subscription: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	filter, err := newPostFilter(*sub, nsfw)
+	if err != nil {
+		followup(discord.MessageCreate{
+			Content: "Invalid filters: " + err.Error(),
+		})
+		return
+	}
+
+	posts, err := b.Reddit.GetPosts(ctx, sub.Subreddit, sub.Type, sub.TimeWindow)
+	if err != nil {
+		followup(discord.MessageCreate{
+			Content: "Failed to get posts: " + err.Error(),
+		})
+		return
+	}
+
+	var sent []RedditPost
+	for _, post := range posts {
+		if filter.allows(post) && sub.meetsThresholds(post) {
+			sent = append(sent, post)
+		}
+	}
+
+	var description string
+	for i, post := range sent {
+		if i == maxFilterTestPosts {
+			description += fmt.Sprintf("- and %d more\n", len(sent)-i)
+			break
+		}
+		description += fmt.Sprintf("- [%s](https://reddit.com%s)\n", cutString(post.Title, 80), post.Permalink)
+	}
+
+	messageCreate := discord.MessageCreate{
+		Content: fmt.Sprintf("%d of the %d current %s posts of [r/%s](<https://reddit.com/r/%s>) would be sent with `%s`", len(sent), len(posts), sub.Type, sub.Subreddit, sub.Subreddit, cutString(sub.FilterExpression, 200)),
+	}
+	if description != "" {
+		messageCreate.Embeds = []discord.Embed{{
+			Description: cutString(description, 4096),
+			Color:       RedditColor,
+		}}
+	}
+	followup(messageCreate)
+}
+
+// timeWindowName returns the display name of a time window.
+func timeWindowName(timeWindow string) string {
+	for _, choice := range timeWindowChoices {
+		if choice.Value == timeWindow {
+			return choice.Name
+		}
+	}
+	return timeWindow
+}
+
+// subscriptionFilters describes the active filters of a subscription.
+func subscriptionFilters(sub Subscription) []string {
+	var filters []string
+	if sub.PostKinds != "" {
+		filters = append(filters, "Kinds: "+strings.ReplaceAll(sub.PostKinds, ",", ", "))
+	}
+	if sub.IncludePatterns != "" {
+		filters = append(filters, "Include: `"+sub.IncludePatterns+"`")
+	}
+	if sub.ExcludePatterns != "" {
+		filters = append(filters, "Exclude: `"+sub.ExcludePatterns+"`")
+	}
+	if sub.FlairAllowlist != "" {
+		filters = append(filters, "Allowed flairs: "+sub.FlairAllowlist)
+	}
+	if sub.FlairDenylist != "" {
+		filters = append(filters, "Denied flairs: "+sub.FlairDenylist)
+	}
+	if !sub.AllowNSFW {
+		filters = append(filters, "NSFW posts: skipped")
+	}
+	if sub.AuthorAllowlist != "" {
+		filters = append(filters, "Allowed authors: "+sub.AuthorAllowlist)
+	}
+	if sub.AuthorDenylist != "" {
+		filters = append(filters, "Denied authors: "+sub.AuthorDenylist)
+	}
+	if sub.AnnouncementsOnly {
+		filters = append(filters, "Announcements only")
+	}
+	if sub.DomainAllowlist != "" {
+		filters = append(filters, "Allowed domains: "+sub.DomainAllowlist)
+	}
+	if sub.DomainDenylist != "" {
+		filters = append(filters, "Denied domains: "+sub.DomainDenylist)
+	}
+	if sub.FilterExpression != "" {
+		filters = append(filters, "Filter: `"+sub.FilterExpression+"`")
+	}
+	if sub.MinScore > 0 {
+		filters = append(filters, fmt.Sprintf("Min score: %d", sub.MinScore))
+	}
+	if sub.MinComments > 0 {
+		filters = append(filters, fmt.Sprintf("Min comments: %d", sub.MinComments))
+	}
+	if sub.MinUpvoteRatio > 0 {
+		filters = append(filters, fmt.Sprintf("Min upvote ratio: %.0f%%", sub.MinUpvoteRatio*100))
+	}
+	return filters
+}
+
 func (b *Bot) OnInfo(event *events.ApplicationCommandInteractionCreate) {
 	_ = event.CreateMessage(discord.MessageCreate{
 		Content: "I'm a bot that sends you reddit posts to discord.\nYou can add subreddits with `/subreddit add <subreddit>`\nYou can remove subreddits with `/subreddit remove <subreddit>`\nYou can list your subreddits with `/subreddit list`You can get help on [GitHub](https://github.com/topi314/Reddit-Discord-Bot)",
@@ -383,13 +1181,30 @@ func (b *Bot) OnDiscordCallback(w http.ResponseWriter, r *http.Request) {
 	webhookToken := wh["token"].(string)
 
 	if err = b.DB.AddSubscription(Subscription{
-		Subreddit:    setupState.Subreddit,
-		Type:         setupState.PostType,
-		FormatType:   setupState.FormatType,
-		GuildID:      *setupState.Interaction.GuildID(),
-		ChannelID:    setupState.Interaction.Channel().ID(),
-		WebhookID:    webhookID,
-		WebhookToken: webhookToken,
+		Subreddit:         setupState.Subreddit,
+		Type:              setupState.PostType,
+		TimeWindow:        setupState.TimeWindow,
+		FormatType:        setupState.FormatType,
+		GuildID:           *setupState.Interaction.GuildID(),
+		ChannelID:         setupState.Interaction.Channel().ID(),
+		WebhookID:         webhookID,
+		WebhookToken:      webhookToken,
+		RemovedPosts:      setupState.RemovedPosts,
+		PostKinds:         setupState.PostKinds,
+		IncludePatterns:   setupState.IncludePatterns,
+		ExcludePatterns:   setupState.ExcludePatterns,
+		FlairAllowlist:    setupState.FlairAllowlist,
+		FlairDenylist:     setupState.FlairDenylist,
+		AllowNSFW:         setupState.AllowNSFW,
+		AuthorAllowlist:   setupState.AuthorAllowlist,
+		AuthorDenylist:    setupState.AuthorDenylist,
+		AnnouncementsOnly: setupState.AnnouncementsOnly,
+		DomainAllowlist:   setupState.DomainAllowlist,
+		DomainDenylist:    setupState.DomainDenylist,
+		FilterExpression:  setupState.FilterExpression,
+		MinScore:          setupState.MinScore,
+		MinComments:       setupState.MinComments,
+		MinUpvoteRatio:    setupState.MinUpvoteRatio,
 	}); err != nil {
 		_, _ = b.Client.Rest().UpdateInteractionResponse(setupState.Interaction.ApplicationID(), setupState.Interaction.Token(), discord.MessageUpdate{
 			Content:    json.Ptr("Failed to save subscription to the database: " + err.Error()),
@@ -0,0 +1,436 @@
+package redditbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxExpressionLength is the max length of a filter expression
+	maxExpressionLength = 1000
+	// maxExpressionDepth is how deep parentheses, ! and - can be nested in a filter expression
+	maxExpressionDepth = 32
+)
+
+// postExpression is a compiled filter expression which returns whether a post should be sent.
+type postExpression func(post RedditPost) bool
+
+type exprType string
+
+const (
+	exprTypeBool   exprType = "boolean"
+	exprTypeNumber exprType = "number"
+	exprTypeString exprType = "string"
+)
+
+// exprField is a field of a post which can be used in filter expressions.
+type exprField struct {
+	typ exprType
+	get func(post RedditPost) any
+}
+
+var exprFields = map[string]exprField{
+	"title":         {exprTypeString, func(p RedditPost) any { return p.Title }},
+	"text":          {exprTypeString, func(p RedditPost) any { return p.Selftext }},
+	"author":        {exprTypeString, func(p RedditPost) any { return p.Author }},
+	"flair":         {exprTypeString, func(p RedditPost) any { return p.LinkFlairText }},
+	"domain":        {exprTypeString, func(p RedditPost) any { return p.Domain }},
+	"url":           {exprTypeString, func(p RedditPost) any { return p.URL }},
+	"kind":          {exprTypeString, func(p RedditPost) any { return string(p.Kind()) }},
+	"distinguished": {exprTypeString, func(p RedditPost) any { return p.Distinguished }},
+	"score":         {exprTypeNumber, func(p RedditPost) any { return float64(p.Score) }},
+	"comments":      {exprTypeNumber, func(p RedditPost) any { return float64(p.NumComments) }},
+	"upvote_ratio":  {exprTypeNumber, func(p RedditPost) any { return p.UpvoteRatio }},
+	"over_18":       {exprTypeBool, func(p RedditPost) any { return p.Over18 }},
+	"spoiler":       {exprTypeBool, func(p RedditPost) any { return p.Spoiler }},
+	"stickied":      {exprTypeBool, func(p RedditPost) any { return p.Stickied }},
+	"is_self":       {exprTypeBool, func(p RedditPost) any { return p.IsSelf }},
+	"announcement":  {exprTypeBool, func(p RedditPost) any { return p.IsAnnouncement() }},
+}
+
+// parseExpression validates a filter expression and returns it in its canonical form, "none" removes it.
+func parseExpression(str string) (string, error) {
+	str = strings.TrimSpace(str)
+	if strings.EqualFold(str, "none") {
+		return "", nil
+	}
+	if _, err := compileExpression(str); err != nil {
+		return "", err
+	}
+	return str, nil
+}
+
+// compileExpression compiles a filter expression like `score > 100 && flair != 'Meme' && !over_18`.
+// Expressions can compare the fields in exprFields with numbers like -5, 'strings' and true or false using ==, !=, <, <=, > and >=,
+// check strings with contains and matches /regex/ and combine conditions with &&, ||, ! and parentheses.
+// Strings are compared ignoring the case. An empty expression allows all posts.
+func compileExpression(str string) (postExpression, error) {
+	if strings.TrimSpace(str) == "" {
+		return nil, nil
+	}
+	if len(str) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+
+	tokens, err := lexExpression(str)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != exprTokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", token.text, token.pos+1)
+	}
+	if node.typ != exprTypeBool {
+		return nil, fmt.Errorf("expression has to be a condition like score > 100 but is a %s", node.typ)
+	}
+
+	eval := node.eval
+	return func(post RedditPost) bool {
+		return eval(post).(bool)
+	}, nil
+}
+
+type exprTokenKind int
+
+const (
+	exprTokenEOF exprTokenKind = iota
+	exprTokenIdent
+	exprTokenNumber
+	exprTokenString
+	exprTokenRegex
+	exprTokenOperator
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+// exprOperators are the operators of filter expressions, longer ones first so they are matched before their prefixes.
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "-", "(", ")"}
+
+func lexExpression(str string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(str); {
+		c := str[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"' || c == '/':
+			value, end, err := lexQuoted(str, i)
+			if err != nil {
+				return nil, err
+			}
+			kind := exprTokenString
+			if c == '/' {
+				kind = exprTokenRegex
+			}
+			tokens = append(tokens, exprToken{kind: kind, text: value, pos: i})
+			i = end
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(str) && (str[i] >= '0' && str[i] <= '9' || str[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenNumber, text: str[start:i], pos: start})
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			start := i
+			for i < len(str) && (str[i] >= 'a' && str[i] <= 'z' || str[i] >= 'A' && str[i] <= 'Z' || str[i] >= '0' && str[i] <= '9' || str[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenIdent, text: strings.ToLower(str[start:i]), pos: start})
+		default:
+			var operator string
+			for _, op := range exprOperators {
+				if strings.HasPrefix(str[i:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenOperator, text: operator, pos: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, exprToken{kind: exprTokenEOF, text: "end of expression", pos: len(str)}), nil
+}
+
+// lexQuoted reads the string or regex starting with the quote at start and returns its value and the index after it.
+// A backslash escapes the quote, in regexes all other escapes are kept as they are.
+func lexQuoted(str string, start int) (string, int, error) {
+	quote := str[start]
+	var value strings.Builder
+	for i := start + 1; i < len(str); i++ {
+		switch str[i] {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\\':
+			if i+1 < len(str) {
+				i++
+				if str[i] != quote && (quote == '/' || str[i] != '\\') {
+					value.WriteByte('\\')
+				}
+			}
+		}
+		value.WriteByte(str[i])
+	}
+	return "", 0, fmt.Errorf("unclosed %c at position %d", quote, start+1)
+}
+
+// exprNode is a compiled part of a filter expression.
+type exprNode struct {
+	typ  exprType
+	eval func(post RedditPost) any
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	depth  int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.pos]
+	if token.kind != exprTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *exprParser) isOperator(operator string) bool {
+	token := p.peek()
+	return token.kind == exprTokenOperator && token.text == operator
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		token := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkTypes(token, exprTypeBool, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			return l(post).(bool) || r(post).(bool)
+		}}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		token := p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkTypes(token, exprTypeBool, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			return l(post).(bool) && r(post).(bool)
+		}}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.peek()
+	switch {
+	case token.kind == exprTokenIdent && token.text == "matches":
+		p.next()
+		regex := p.next()
+		if regex.kind != exprTokenRegex && regex.kind != exprTokenString {
+			return nil, fmt.Errorf("matches needs a /regex/ at position %d", regex.pos+1)
+		}
+		re, err := regexp.Compile("(?i)" + regex.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex at position %d: %w", regex.pos+1, err)
+		}
+		if left.typ != exprTypeString {
+			return nil, fmt.Errorf("matches at position %d needs a string but got a %s", token.pos+1, left.typ)
+		}
+		l := left.eval
+		return &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			return re.MatchString(l(post).(string))
+		}}, nil
+
+	case token.kind == exprTokenIdent && token.text == "contains":
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkTypes(token, exprTypeString, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		return &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			return strings.Contains(strings.ToLower(l(post).(string)), strings.ToLower(r(post).(string)))
+		}}, nil
+
+	case token.kind == exprTokenOperator && (token.text == "==" || token.text == "!="):
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkTypes(token, left.typ, left, right); err != nil {
+			return nil, err
+		}
+		l, r, negate := left.eval, right.eval, token.text == "!="
+		return &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			lv, rv := l(post), r(post)
+			if ls, ok := lv.(string); ok {
+				return strings.EqualFold(ls, rv.(string)) != negate
+			}
+			return (lv == rv) != negate
+		}}, nil
+
+	case token.kind == exprTokenOperator && (token.text == "<" || token.text == "<=" || token.text == ">" || token.text == ">="):
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkTypes(token, exprTypeNumber, left, right); err != nil {
+			return nil, err
+		}
+		l, r, operator := left.eval, right.eval, token.text
+		return &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+			lv, rv := l(post).(float64), r(post).(float64)
+			switch operator {
+			case "<":
+				return lv < rv
+			case "<=":
+				return lv <= rv
+			case ">":
+				return lv > rv
+			default:
+				return lv >= rv
+			}
+		}}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	if !p.isOperator("!") && !p.isOperator("-") {
+		return p.parsePrimary()
+	}
+
+	token := p.next()
+	if p.depth++; p.depth > maxExpressionDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+	}
+	operand, err := p.parseUnary()
+	p.depth--
+	if err != nil {
+		return nil, err
+	}
+	eval := operand.eval
+	if token.text == "-" {
+		if err = checkTypes(token, exprTypeNumber, operand); err != nil {
+			return nil, err
+		}
+		return &exprNode{typ: exprTypeNumber, eval: func(post RedditPost) any {
+			return -eval(post).(float64)
+		}}, nil
+	}
+	if err = checkTypes(token, exprTypeBool, operand); err != nil {
+		return nil, err
+	}
+	return &exprNode{typ: exprTypeBool, eval: func(post RedditPost) any {
+		return !eval(post).(bool)
+	}}, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	token := p.next()
+	switch token.kind {
+	case exprTokenNumber:
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", token.text, token.pos+1)
+		}
+		return literalNode(exprTypeNumber, value), nil
+
+	case exprTokenString:
+		return literalNode(exprTypeString, token.text), nil
+
+	case exprTokenIdent:
+		switch token.text {
+		case "true", "false":
+			return literalNode(exprTypeBool, token.text == "true"), nil
+		}
+		field, ok := exprFields[token.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s at position %d", token.text, token.pos+1)
+		}
+		return &exprNode{typ: field.typ, eval: field.get}, nil
+
+	case exprTokenOperator:
+		if token.text != "(" {
+			break
+		}
+		if p.depth++; p.depth > maxExpressionDepth {
+			return nil, fmt.Errorf("expression is nested deeper than %d levels", maxExpressionDepth)
+		}
+		node, err := p.parseOr()
+		p.depth--
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != exprTokenOperator || closing.text != ")" {
+			return nil, fmt.Errorf("expected ) at position %d but got %s", closing.pos+1, closing.text)
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", token.text, token.pos+1)
+}
+
+func literalNode(typ exprType, value any) *exprNode {
+	return &exprNode{typ: typ, eval: func(RedditPost) any {
+		return value
+	}}
+}
+
+// checkTypes returns an error if one of the operands of an operator doesn't have the type it needs.
+func checkTypes(operator exprToken, typ exprType, operands ...*exprNode) error {
+	for _, operand := range operands {
+		if operand.typ != typ {
+			return fmt.Errorf("%s at position %d needs a %s but got a %s", operator.text, operator.pos+1, typ, operand.typ)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package redditbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	post := RedditPost{
+		Title:         `Release v1.2 "Bobcat" is out`,
+		Selftext:      `It's finally here`,
+		Author:        "topi314",
+		LinkFlairText: "News",
+		Domain:        "self.golang",
+		Score:         -3,
+		NumComments:   42,
+		UpvoteRatio:   0.5,
+		IsSelf:        true,
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		err        string
+	}{
+		{name: "empty", expression: "  ", want: true},
+		{name: "number", expression: "comments > 40", want: true},
+		{name: "float", expression: "upvote_ratio >= 0.5", want: true},
+		{name: "negative number", expression: "score > -5", want: true},
+		{name: "negative field", expression: "-score == 3", want: true},
+		{name: "string ignores case", expression: "flair == 'NEWS'", want: true},
+		{name: "not equal", expression: "author != 'topi314'", want: false},
+		{name: "bool field", expression: "is_self", want: true},
+		{name: "bool literal", expression: "over_18 == false", want: true},
+		{name: "and before or", expression: "true || false && false", want: true},
+		{name: "and before or reversed", expression: "false && false || true", want: true},
+		{name: "parentheses", expression: "(true || false) && false", want: false},
+		{name: "not", expression: "!over_18 && !!is_self", want: true},
+		{name: "contains", expression: "title contains 'BOBCAT'", want: true},
+		{name: "contains field", expression: "title contains flair", want: false},
+		{name: "matches", expression: `title matches /v\d+\.\d+/`, want: true},
+		{name: "matches ignores case", expression: "text matches /^it's FINALLY/", want: true},
+		{name: "matches string", expression: "domain matches 'self\\..*'", want: true},
+		{name: "escaped single quote", expression: `text contains 'it\'s'`, want: true},
+		{name: "escaped double quote", expression: `title contains "\"Bobcat\""`, want: true},
+		{name: "escaped backslash", expression: `title contains '\\'`, want: false},
+		{name: "escaped regex slash", expression: `title matches /v1\.2 \/?/`, want: true},
+
+		{name: "unknown field", expression: "votes > 1", err: "unknown field votes at position 1"},
+		{name: "bad token", expression: "score > 1 & comments > 1", err: `unexpected '&' at position 11`},
+		{name: "unclosed string", expression: "flair == 'news", err: "unclosed ' at position 10"},
+		{name: "unclosed regex", expression: "title matches /news", err: "unclosed / at position 15"},
+		{name: "invalid regex", expression: "title matches /(/", err: "invalid regex at position 15"},
+		{name: "matches needs regex", expression: "title matches 1", err: "matches needs a /regex/ at position 15"},
+		{name: "matches needs string", expression: "score matches /1/", err: "matches at position 7 needs a string but got a number"},
+		{name: "contains needs string", expression: "title contains 1", err: "contains at position 7 needs a string but got a number"},
+		{name: "compare number with string", expression: "score == 'high'", err: "== at position 7 needs a number but got a string"},
+		{name: "order strings", expression: "title > 'a'", err: "> at position 7 needs a number but got a string"},
+		{name: "and needs conditions", expression: "score && true", err: "&& at position 7 needs a boolean but got a number"},
+		{name: "not needs condition", expression: "!score", err: "! at position 1 needs a boolean but got a number"},
+		{name: "minus needs number", expression: "-over_18", err: "- at position 1 needs a number but got a boolean"},
+		{name: "no condition", expression: "score", err: "expression has to be a condition like score > 100 but is a number"},
+		{name: "trailing token", expression: "is_self is_self", err: "unexpected is_self at position 9"},
+		{name: "missing operand", expression: "score >", err: "unexpected end of expression at position 8"},
+		{name: "unclosed parenthesis", expression: "(is_self", err: "expected ) at position 9 but got end of expression"},
+		{name: "invalid number", expression: "score > 1.2.3", err: "invalid number 1.2.3 at position 9"},
+		{name: "max not depth", expression: strings.Repeat("!", maxExpressionDepth) + "is_self", want: true},
+		{name: "not too deep", expression: strings.Repeat("!", maxExpressionDepth+1) + "is_self", err: "expression is nested deeper than 32 levels"},
+		{name: "max parentheses depth", expression: strings.Repeat("(", maxExpressionDepth) + "is_self" + strings.Repeat(")", maxExpressionDepth), want: true},
+		{name: "parentheses too deep", expression: strings.Repeat("(", maxExpressionDepth+1) + "is_self" + strings.Repeat(")", maxExpressionDepth+1), err: "expression is nested deeper than 32 levels"},
+		{name: "too long", expression: "is_self" + strings.Repeat(" ", maxExpressionLength), err: "expression is longer than 1000 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := compileExpression(tt.expression)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("compileExpression(%q) error = %v, want %q", tt.expression, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileExpression(%q) error = %v", tt.expression, err)
+			}
+			if got := expression == nil || expression(post); got != tt.want {
+				t.Errorf("compileExpression(%q)(post) = %v, want %v", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
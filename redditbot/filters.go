@@ -0,0 +1,492 @@
+package redditbot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PostKind is what a post is made of, subscriptions can be limited to some kinds.
+type PostKind string
+
+const (
+	PostKindSelf      PostKind = "self"
+	PostKindLink      PostKind = "link"
+	PostKindImage     PostKind = "image"
+	PostKindGallery   PostKind = "gallery"
+	PostKindVideo     PostKind = "video"
+	PostKindPoll      PostKind = "poll"
+	PostKindCrosspost PostKind = "crosspost"
+)
+
+var postKinds = []PostKind{PostKindSelf, PostKindLink, PostKindImage, PostKindGallery, PostKindVideo, PostKindPoll, PostKindCrosspost}
+
+// Kind classifies a post. Crossposts and polls win over their content and GIFs count as images.
+func (p RedditPost) Kind() PostKind {
+	switch {
+	case p.CrosspostParent != "":
+		return PostKindCrosspost
+	case p.PollData != nil:
+		return PostKindPoll
+	case p.IsGallery:
+		return PostKindGallery
+	case p.IsGIF() || p.PostHint == "image" || imageRegex.MatchString(p.URL):
+		return PostKindImage
+	case p.Video() != nil || p.IsVideo || p.PostHint == "hosted:video" || p.PostHint == "rich:video":
+		return PostKindVideo
+	case p.IsSelf:
+		return PostKindSelf
+	default:
+		return PostKindLink
+	}
+}
+
+// parsePostKinds parses a comma separated list of post kinds into its canonical form.
+// An empty list or "all" allows all kinds and is stored as an empty string.
+func parsePostKinds(str string) (string, error) {
+	allowed := map[PostKind]bool{}
+	for _, kind := range strings.Split(str, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			continue
+		}
+		if kind == "all" {
+			return "", nil
+		}
+		if !isPostKind(PostKind(kind)) {
+			return "", fmt.Errorf("unknown post kind %q, must be one of: %s", kind, joinPostKinds(postKinds, ", "))
+		}
+		allowed[PostKind(kind)] = true
+	}
+
+	var kinds []PostKind
+	for _, kind := range postKinds {
+		if allowed[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == len(postKinds) {
+		return "", nil
+	}
+	return joinPostKinds(kinds, ","), nil
+}
+
+func isPostKind(kind PostKind) bool {
+	for _, k := range postKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPostKinds(kinds []PostKind, sep string) string {
+	strs := make([]string, len(kinds))
+	for i, kind := range kinds {
+		strs[i] = string(kind)
+	}
+	return strings.Join(strs, sep)
+}
+
+// allowsKind returns whether a subscription sends posts of the given kind.
+func (s Subscription) allowsKind(kind PostKind) bool {
+	if s.PostKinds == "" {
+		return true
+	}
+	for _, k := range strings.Split(s.PostKinds, ",") {
+		if PostKind(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePatterns parses a comma separated list of keywords and regular expressions written as /regex/ into its canonical form.
+// "none" removes all patterns.
+func parsePatterns(str string) (string, error) {
+	if strings.EqualFold(strings.TrimSpace(str), "none") {
+		return "", nil
+	}
+	patterns, err := splitPatterns(str)
+	if err != nil {
+		return "", err
+	}
+	for _, pattern := range patterns {
+		if _, err = compilePattern(pattern); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(patterns, ", "), nil
+}
+
+// splitPatterns splits a comma separated list of patterns, commas inside of regular expressions don't separate patterns.
+func splitPatterns(str string) ([]string, error) {
+	var patterns []string
+	for str = strings.TrimSpace(str); str != ""; str = strings.TrimSpace(str) {
+		var pattern string
+		if strings.HasPrefix(str, "/") {
+			end := regexEnd(str)
+			if end == -1 {
+				return nil, fmt.Errorf("regex %s is missing its closing /", str)
+			}
+			pattern, str = str[:end+1], strings.TrimSpace(str[end+1:])
+			if str != "" && !strings.HasPrefix(str, ",") {
+				return nil, fmt.Errorf("expected , after regex %s", pattern)
+			}
+		} else {
+			i := strings.Index(str, ",")
+			if i == -1 {
+				i = len(str)
+			}
+			pattern, str = strings.TrimSpace(str[:i]), str[i:]
+		}
+		str = strings.TrimPrefix(str, ",")
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// regexEnd returns the index of the / closing the regex at the start of str or -1.
+func regexEnd(str string) int {
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+// compilePattern compiles a keyword into a case-insensitive regex matching the whole word or a /regex/ as is.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", pattern, err)
+		}
+		return re, nil
+	}
+	// \b doesn't work for keywords which start or end with punctuation like c++
+	return regexp.MustCompile(`(?i)(?:^|\W)` + regexp.QuoteMeta(pattern) + `(?:$|\W)`), nil
+}
+
+func compilePatterns(str string) ([]*regexp.Regexp, error) {
+	patterns, err := splitPatterns(str)
+	if err != nil {
+		return nil, err
+	}
+	regexes := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		if regexes[i], err = compilePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return regexes, nil
+}
+
+// parseFlairs parses a comma separated list of flairs into its canonical form, "none" removes all flairs.
+func parseFlairs(str string) string {
+	if strings.EqualFold(strings.TrimSpace(str), "none") {
+		return ""
+	}
+	var flairs []string
+	for _, flair := range strings.Split(str, ",") {
+		if flair = strings.TrimSpace(flair); flair != "" {
+			flairs = append(flairs, flair)
+		}
+	}
+	return strings.Join(flairs, ", ")
+}
+
+// containsName returns whether a comma separated list of flairs or authors contains a name ignoring the case.
+func containsName(names string, name string) bool {
+	name = strings.TrimSpace(name)
+	for _, n := range strings.Split(names, ",") {
+		if strings.EqualFold(strings.TrimSpace(n), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsFlair returns whether a subscription sends posts with the given flair.
+// Posts without a flair are dropped when there is an allowlist.
+func (s Subscription) allowsFlair(flair string) bool {
+	if s.FlairAllowlist != "" && !containsName(s.FlairAllowlist, flair) {
+		return false
+	}
+	return s.FlairDenylist == "" || flair == "" || !containsName(s.FlairDenylist, flair)
+}
+
+// parseAuthors parses a comma separated list of usernames with or without u/ into its canonical form, "none" removes all authors.
+func parseAuthors(str string) string {
+	if strings.EqualFold(strings.TrimSpace(str), "none") {
+		return ""
+	}
+	var authors []string
+	for _, author := range strings.Split(str, ",") {
+		author = strings.TrimSpace(author)
+		author = strings.TrimPrefix(strings.TrimPrefix(author, "/"), "u/")
+		if author != "" {
+			authors = append(authors, author)
+		}
+	}
+	return strings.Join(authors, ", ")
+}
+
+// allowsAuthor returns whether a subscription sends posts of the given author.
+func (s Subscription) allowsAuthor(author string) bool {
+	if s.AuthorAllowlist != "" && !containsName(s.AuthorAllowlist, author) {
+		return false
+	}
+	return s.AuthorDenylist == "" || !containsName(s.AuthorDenylist, author)
+}
+
+// editDomains applies a comma separated list of domains to the current list and returns the new list in its canonical form.
+// Domains prefixed with + are added and ones prefixed with - are removed, otherwise the list is replaced. "none" removes all domains.
+// *.example.com matches example.com and all of its subdomains.
+func editDomains(current string, str string) (string, error) {
+	if strings.EqualFold(strings.TrimSpace(str), "none") {
+		return "", nil
+	}
+
+	var domains []string
+	if current != "" {
+		domains = strings.Split(current, ", ")
+	}
+	var (
+		replaced []string
+		edited   bool
+	)
+	for _, domain := range strings.Split(str, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+
+		op := domain[0]
+		if op == '+' || op == '-' {
+			edited = true
+			domain = strings.TrimSpace(domain[1:])
+		}
+		if !isDomainPattern(domain) {
+			return "", fmt.Errorf("invalid domain: %s", domain)
+		}
+
+		i := indexOf(domains, domain)
+		switch {
+		case op == '+' && i == -1:
+			domains = append(domains, domain)
+		case op == '-' && i != -1:
+			domains = append(domains[:i], domains[i+1:]...)
+		case op != '+' && op != '-':
+			replaced = append(replaced, domain)
+		}
+	}
+	if edited && len(replaced) > 0 {
+		return "", errors.New("either add and remove domains with + and - or replace all of them")
+	}
+	if !edited {
+		domains = replaced
+	}
+	return strings.Join(domains, ", "), nil
+}
+
+func indexOf(strs []string, str string) int {
+	for i, s := range strs {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
+
+// isDomainPattern returns whether a string is a domain like example.com or a wildcard domain like *.example.com.
+func isDomainPattern(domain string) bool {
+	domain = strings.TrimPrefix(domain, "*.")
+	if domain == "" || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return false
+	}
+	for _, r := range domain {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '.' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesDomain returns whether a domain matches one of a comma separated list of domains.
+func matchesDomain(domains string, domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, d := range strings.Split(domains, ",") {
+		d = strings.TrimSpace(d)
+		if wildcard := strings.TrimPrefix(d, "*."); wildcard != d {
+			if domain == wildcard || strings.HasSuffix(domain, "."+wildcard) {
+				return true
+			}
+			continue
+		}
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// redditDomains are the domains of posts hosted by Reddit itself like images, videos and galleries.
+var redditDomains = []string{"i.redd.it", "v.redd.it", "reddit.com", "www.reddit.com"}
+
+// isRedditDomain returns whether a post with the given domain is hosted by Reddit, self posts have domains like self.golang.
+func isRedditDomain(domain string) bool {
+	domain = strings.ToLower(domain)
+	return strings.HasPrefix(domain, "self.") || indexOf(redditDomains, domain) != -1
+}
+
+// allowsDomain returns whether a subscription sends posts linking to the given domain.
+// Self posts and posts hosted by Reddit don't link anywhere else and are never dropped by the domain filters,
+// all other posts are checked, like images on imgur or videos on youtube.
+func (s Subscription) allowsDomain(post RedditPost) bool {
+	if post.IsSelf || isRedditDomain(post.Domain) {
+		return true
+	}
+	if s.DomainAllowlist != "" && !matchesDomain(s.DomainAllowlist, post.Domain) {
+		return false
+	}
+	return s.DomainDenylist == "" || !matchesDomain(s.DomainDenylist, post.Domain)
+}
+
+// postFilter holds the compiled filters of a subscription.
+type postFilter struct {
+	sub     Subscription
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// expression is nil if the subscription has no filter expression
+	expression postExpression
+	// nsfw is whether the subscription allows NSFW posts and its channel is age-restricted
+	nsfw bool
+}
+
+func newPostFilter(sub Subscription, nsfw bool) (*postFilter, error) {
+	include, err := compilePatterns(sub.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(sub.ExcludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	expression, err := compileExpression(sub.FilterExpression)
+	if err != nil {
+		return nil, err
+	}
+	return &postFilter{
+		sub:        sub,
+		include:    include,
+		exclude:    exclude,
+		expression: expression,
+		nsfw:       nsfw,
+	}, nil
+}
+
+// filterCacheTTL is how long the compiled filters of a subscription are kept after its last check.
+const filterCacheTTL = time.Hour
+
+// cachedFilter is a compiled filter of a subscription together with the patterns and filter expression it was compiled from.
+type cachedFilter struct {
+	includePatterns  string
+	excludePatterns  string
+	filterExpression string
+	include          []*regexp.Regexp
+	exclude          []*regexp.Regexp
+	expression       postExpression
+}
+
+// subscriptionFilter returns the filter of a subscription from the cache and only compiles it when its patterns or filter expression changed.
+func (b *Bot) subscriptionFilter(sub Subscription, nsfw bool) (*postFilter, error) {
+	cached, ok := b.filters.get(sub.WebhookID)
+	if !ok || cached.includePatterns != sub.IncludePatterns || cached.excludePatterns != sub.ExcludePatterns || cached.filterExpression != sub.FilterExpression {
+		filter, err := newPostFilter(sub, nsfw)
+		if err != nil {
+			return nil, err
+		}
+		cached = cachedFilter{
+			includePatterns:  sub.IncludePatterns,
+			excludePatterns:  sub.ExcludePatterns,
+			filterExpression: sub.FilterExpression,
+			include:          filter.include,
+			exclude:          filter.exclude,
+			expression:       filter.expression,
+		}
+	}
+	// every check keeps the filter cached for longer
+	b.filters.set(sub.WebhookID, cached, filterCacheTTL)
+
+	return &postFilter{
+		sub:        sub,
+		include:    cached.include,
+		exclude:    cached.exclude,
+		expression: cached.expression,
+		nsfw:       nsfw,
+	}, nil
+}
+
+// allows returns whether a post passes the filters of a subscription.
+// Posts have to match one of the include patterns if there are any and none of the exclude patterns in their title or text.
+// The filter expression is checked last.
+func (f *postFilter) allows(post RedditPost) bool {
+	if post.Over18 && !f.nsfw {
+		return false
+	}
+	if f.sub.AnnouncementsOnly && !post.IsAnnouncement() {
+		return false
+	}
+	if !f.sub.allowsKind(post.Kind()) || !f.sub.allowsFlair(post.LinkFlairText) || !f.sub.allowsAuthor(post.Author) || !f.sub.allowsDomain(post) {
+		return false
+	}
+
+	text := post.Title + "\n" + post.Selftext
+	if len(f.include) > 0 && !matchesAny(f.include, text) {
+		return false
+	}
+	if matchesAny(f.exclude, text) {
+		return false
+	}
+	return f.expression == nil || f.expression(post)
+}
+
+func matchesAny(regexes []*regexp.Regexp, text string) bool {
+	for _, re := range regexes {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasThresholds returns whether a subscription holds back posts until they reach a min score, comments or upvote ratio.
+func (s Subscription) hasThresholds() bool {
+	return s.MinScore > 0 || s.MinComments > 0 || s.MinUpvoteRatio > 0
+}
+
+// validateThresholds returns an error if a subscription has thresholds but its listing can't hold back posts.
+// Posts of "new" listings are too fresh to reach any and the cursor would move past the held back ones.
+func (s Subscription) validateThresholds() error {
+	if s.Type == "new" && s.hasThresholds() {
+		return errors.New("thresholds don't work with new subscriptions")
+	}
+	return nil
+}
+
+// meetsThresholds returns whether a post reached the min score, comments and upvote ratio of a subscription.
+func (s Subscription) meetsThresholds(post RedditPost) bool {
+	if s.Type == "new" {
+		return true
+	}
+	return post.Score >= s.MinScore && post.NumComments >= s.MinComments && post.UpvoteRatio >= s.MinUpvoteRatio
+}
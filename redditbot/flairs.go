@@ -0,0 +1,26 @@
+package redditbot
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// flairCacheTTL is how long the flairs of a subreddit are cached for autocompletion.
+const flairCacheTTL = 10 * time.Minute
+
+// recentFlairs returns the flairs of the latest posts of a subreddit.
+// They are cached, so autocompletion doesn't request them on every key press.
+func (b *Bot) recentFlairs(ctx context.Context, subreddit string) ([]string, error) {
+	key := strings.ToLower(subreddit)
+	if flairs, ok := b.flairs.get(key); ok {
+		return flairs, nil
+	}
+
+	flairs, err := b.Reddit.GetFlairs(ctx, subreddit)
+	if err != nil {
+		return nil, err
+	}
+	b.flairs.set(key, flairs, flairCacheTTL)
+	return flairs, nil
+}
@@ -0,0 +1,92 @@
+package redditbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/disgoorg/log"
+)
+
+const (
+	// leaseDuration is how long a replica holds a subscription without renewing its lease.
+	// When a replica dies, its subscriptions are taken over by the other replicas after this time.
+	leaseDuration = 2 * time.Minute
+	// leaseRenewInterval is how often leases are renewed and balanced between the replicas
+	leaseRenewInterval = 30 * time.Second
+	// replicaRetention is how long a replica which stopped renewing its leases is kept in the replicas table
+	replicaRetention = 24 * time.Hour
+)
+
+// ListenLeases renews the leases of this replica until ctx is done.
+// Subreddit listings are split evenly between all replicas which are alive, so several instances of the bot can share one database.
+func (b *Bot) ListenLeases(ctx context.Context) {
+	for {
+		b.balanceLeases(time.Now())
+		if !sleep(ctx, leaseRenewInterval) {
+			log.Info("stopped renewing leases")
+			return
+		}
+	}
+}
+
+func (b *Bot) balanceLeases(now time.Time) {
+	if err := b.DB.UpdateReplica(b.Cfg.ReplicaID, now); err != nil {
+		log.Error("error updating replica:", err.Error())
+		return
+	}
+
+	replicas, err := b.DB.CountReplicas(now.Add(-leaseDuration))
+	if err != nil {
+		log.Error("error counting replicas:", err.Error())
+		return
+	}
+	total, err := b.DB.CountListings()
+	if err != nil {
+		log.Error("error counting listings:", err.Error())
+		return
+	}
+	share := (total + replicas - 1) / replicas
+
+	expires := now.Add(leaseDuration)
+	held, err := b.DB.RenewLeases(b.Cfg.ReplicaID, now, expires)
+	if err != nil {
+		log.Error("error renewing leases:", err.Error())
+		return
+	}
+
+	switch {
+	case held > share:
+		// give the other replicas a chance to claim their share
+		released, err := b.DB.ReleaseLeases(b.Cfg.ReplicaID, held-share)
+		if err != nil {
+			log.Error("error releasing leases:", err.Error())
+			return
+		}
+		if released > 0 {
+			log.Debugf("released the leases of %d subscriptions, holding %d listings, %d replicas share %d listings", released, held, replicas, total)
+		}
+	case held < share:
+		claimed, err := b.DB.ClaimLeases(b.Cfg.ReplicaID, now, expires, share-held)
+		if err != nil {
+			log.Error("error claiming leases:", err.Error())
+			return
+		}
+		if claimed > 0 {
+			log.Debugf("claimed the leases of %d subscriptions, holding %d listings, %d replicas share %d listings", claimed, held, replicas, total)
+		}
+	}
+
+	if err = b.DB.RemoveReplicasBefore(now.Add(-replicaRetention)); err != nil {
+		log.Error("error removing old replicas:", err.Error())
+	}
+}
+
+// ReleaseLeases gives all leases of this replica back, so other replicas can take over its subscriptions right away.
+func (b *Bot) ReleaseLeases() {
+	if err := b.DB.ReleaseAllLeases(b.Cfg.ReplicaID); err != nil {
+		log.Error("error releasing leases:", err.Error())
+	}
+	if err := b.DB.RemoveReplica(b.Cfg.ReplicaID); err != nil {
+		log.Error("error removing replica:", err.Error())
+	}
+}
@@ -0,0 +1,252 @@
+package redditbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/json"
+	"github.com/disgoorg/log"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// outboxBatchSize is the max number of outbox entries fetched at once
+	outboxBatchSize = 100
+	// outboxRetryBaseDelay is the delay before the first retry of a failed delivery, it doubles with every retry
+	outboxRetryBaseDelay = 5 * time.Second
+	// outboxRetryMaxDelay caps the delay between retries of a failed delivery
+	outboxRetryMaxDelay = time.Hour
+)
+
+// enqueuePost renders a post for a subscription and adds it to the outbox.
+func (b *Bot) enqueuePost(sub Subscription, post RedditPost) error {
+	payload, err := json.Marshal(b.renderPost(sub, post))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return b.DB.AddOutboxEntry(b.Cfg.ReplicaID, OutboxEntry{
+		WebhookID:   sub.WebhookID,
+		PostName:    post.Name,
+		PostCreated: time.Unix(int64(post.CreatedUtc), 0),
+		Payload:     string(payload),
+		NextAttempt: now,
+		CreatedAt:   now,
+	})
+}
+
+// ListenOutbox delivers the posts in the outbox with a pool of workers until ctx is done.
+// Only one post per webhook is delivered at a time, so posts arrive in the order they were added.
+// Once ctx is done, the workers finish the deliveries they are working on and the queued posts stay in the outbox.
+func (b *Bot) ListenOutbox(ctx context.Context) {
+	var (
+		queue    = make(chan OutboxEntry, outboxBatchSize)
+		wake     = make(chan struct{}, 1)
+		inFlight = map[snowflake.ID]struct{}{}
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < b.Cfg.Discord.DeliveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				deliveryQueueDepth.Dec()
+				if ctx.Err() == nil {
+					b.deliverOutboxEntry(entry)
+				}
+
+				mu.Lock()
+				delete(inFlight, entry.WebhookID)
+				mu.Unlock()
+
+				// the next post of this webhook might be due now
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	defer func() {
+		close(queue)
+		wg.Wait()
+		log.Info("stopped delivering posts")
+	}()
+
+	for {
+		entries, err := b.DB.GetDueOutboxEntries(b.Cfg.ReplicaID, time.Now(), outboxBatchSize)
+		if err != nil {
+			log.Error("error getting outbox entries:", err.Error())
+		}
+
+		for _, entry := range entries {
+			mu.Lock()
+			_, ok := inFlight[entry.WebhookID]
+			if !ok {
+				inFlight[entry.WebhookID] = struct{}{}
+			}
+			mu.Unlock()
+			if ok {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case queue <- entry:
+				deliveryQueueDepth.Inc()
+			}
+		}
+
+		timer := time.NewTimer(time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverOutboxEntry sends a queued post to its webhook.
+// The entry is read again first, it might have been delivered or retried since it was fetched with an older batch.
+func (b *Bot) deliverOutboxEntry(queued OutboxEntry) {
+	entry, err := b.DB.GetOutboxEntry(queued.WebhookID, queued.PostName)
+	if errors.Is(err, ErrOutboxEntryNotFound) {
+		return
+	} else if err != nil {
+		log.Errorf("error getting outbox entry %s of webhook %s: %s", queued.PostName, queued.WebhookID, err.Error())
+		return
+	}
+	if entry.NextAttempt.After(time.Now()) {
+		return
+	}
+
+	sub, err := b.DB.GetSubscription(entry.WebhookID)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		if err = b.DB.RemoveOutboxEntries(entry.WebhookID); err != nil {
+			log.Errorf("error removing outbox of removed webhook %s: %s", entry.WebhookID, err.Error())
+		}
+		return
+	} else if err != nil {
+		log.Errorf("error getting subscription for webhook %s: %s", entry.WebhookID, err.Error())
+		return
+	}
+
+	// the lease might have moved since the entry was queued, only the replica holding it may deliver
+	if sub.LeaseOwner != b.Cfg.ReplicaID || !sub.LeaseExpires.After(time.Now()) {
+		log.Debugf("not sending post %s to webhook %d, it's leased by another replica", entry.PostName, sub.WebhookID)
+		return
+	}
+
+	var messageCreate discord.WebhookMessageCreate
+	if err = json.Unmarshal([]byte(entry.Payload), &messageCreate); err != nil {
+		b.deadLetter(*sub, *entry, err)
+		return
+	}
+
+	var message *discord.Message
+	if b.Cfg.TestMode {
+		log.Debugf("sending post %s to webhook %d", entry.PostName, sub.WebhookID)
+	} else if message, err = b.Client.Rest().CreateWebhookMessage(sub.WebhookID, sub.WebhookToken, messageCreate, true, 0); err != nil {
+		b.failDelivery(*sub, *entry, err)
+		return
+	}
+
+	b.delivered(*sub, *entry, message)
+	deliveryLatency.Observe(time.Now().Sub(entry.CreatedAt).Seconds())
+}
+
+// delivered moves the cursor of a subscription past a delivered post and removes it from the outbox.
+// The message is kept so it can be updated when the post is edited or removed, it's nil in test mode.
+func (b *Bot) delivered(sub Subscription, entry OutboxEntry, message *discord.Message) {
+	if err := b.DB.AddSeenPost(sub.WebhookID, entry.PostName); err != nil {
+		log.Errorf("error adding seen post %s for webhook %s: %s", entry.PostName, sub.WebhookID, err.Error())
+		return
+	}
+	if err := b.DB.RemoveOutboxEntry(sub.WebhookID, entry.PostName); err != nil {
+		log.Errorf("error removing post %s from outbox of webhook %s: %s", entry.PostName, sub.WebhookID, err.Error())
+	}
+	if message != nil && b.Cfg.Reddit.RefreshWindow > 0 {
+		if err := b.DB.AddDeliveredMessage(DeliveredMessage{
+			WebhookID:   sub.WebhookID,
+			PostName:    entry.PostName,
+			MessageID:   message.ID,
+			Payload:     entry.Payload,
+			DeliveredAt: time.Now(),
+		}); err != nil {
+			log.Errorf("error adding message of post %s for webhook %s: %s", entry.PostName, sub.WebhookID, err.Error())
+		}
+	}
+	if sub.Type == "new" && entry.PostCreated.After(sub.LastPost) {
+		if err := b.DB.UpdateSubscriptionLastPost(sub.WebhookID, entry.PostCreated); err != nil {
+			log.Errorf("error updating last post for webhook %s: %s", sub.WebhookID, err.Error())
+		}
+	}
+
+	postsSent.With(prometheus.Labels{
+		"subreddit":  sub.Subreddit,
+		"type":       sub.Type,
+		"webhook_id": strconv.FormatUint(uint64(sub.WebhookID), 10),
+		"guild_id":   strconv.FormatUint(uint64(sub.GuildID), 10),
+		"channel_id": strconv.FormatUint(uint64(sub.ChannelID), 10),
+	}).Inc()
+}
+
+// failDelivery schedules a retry of a failed delivery or moves it to the dead letters if it can't succeed.
+func (b *Bot) failDelivery(sub Subscription, entry OutboxEntry, err error) {
+	log.Errorf("error sending post %s to webhook %d: %s", entry.PostName, sub.WebhookID, err.Error())
+
+	var restError rest.Error
+	if errors.As(err, &restError) && restError.Response != nil {
+		switch statusCode := restError.Response.StatusCode; {
+		case statusCode == http.StatusNotFound:
+			if err = b.RemoveSubscription(sub.WebhookID, sub.WebhookToken, nil); err != nil {
+				log.Errorf("error removing sub for webhook %s: %s", sub.WebhookID, err.Error())
+			}
+			return
+		case statusCode >= 400 && statusCode < 500 && statusCode != http.StatusTooManyRequests:
+			// discord won't accept this message no matter how often we retry
+			b.deadLetter(sub, entry, err)
+			return
+		}
+	}
+
+	attempts := entry.Attempts + 1
+	if attempts >= b.Cfg.Discord.MaxDeliveryAttempts {
+		b.deadLetter(sub, entry, err)
+		return
+	}
+
+	nextAttempt := time.Now().Add(backoff(attempts-1, outboxRetryBaseDelay, outboxRetryMaxDelay))
+	if err = b.DB.UpdateOutboxEntryAttempt(sub.WebhookID, entry.PostName, attempts, nextAttempt, err.Error()); err != nil {
+		log.Errorf("error updating outbox entry %s of webhook %s: %s", entry.PostName, sub.WebhookID, err.Error())
+	}
+}
+
+// deadLetter gives up on delivering a post. The post is marked as seen so it isn't queued again.
+func (b *Bot) deadLetter(sub Subscription, entry OutboxEntry, err error) {
+	log.Warnf("giving up on sending post %s to webhook %d after %d attempts: %s", entry.PostName, sub.WebhookID, entry.Attempts+1, err.Error())
+	entry.Attempts++
+
+	if aErr := b.DB.AddSeenPost(sub.WebhookID, entry.PostName); aErr != nil {
+		log.Errorf("error adding seen post %s for webhook %s: %s", entry.PostName, sub.WebhookID, aErr.Error())
+		return
+	}
+	if mErr := b.DB.MoveOutboxEntryToDeadLetters(entry, err.Error()); mErr != nil {
+		log.Errorf("error moving post %s of webhook %s to dead letters: %s", entry.PostName, sub.WebhookID, mErr.Error())
+	}
+}
@@ -0,0 +1,551 @@
+package redditbot
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/log"
+)
+
+func (b *Bot) targetTime() time.Duration {
+	return time.Minute / time.Duration(b.Cfg.Reddit.RequestsPerMinute)
+}
+
+// ListenSubreddits checks the due subscriptions for new posts until ctx is done.
+// Once ctx is done, the subscription which is currently being delivered to is finished before returning.
+func (b *Bot) ListenSubreddits(ctx context.Context) {
+	for {
+		b.checkSubreddits(ctx)
+		if !sleep(ctx, 5*time.Second) {
+			log.Info("stopped listening to subreddits")
+			return
+		}
+	}
+}
+
+// sleep waits for d and returns false if ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (b *Bot) checkSubreddits(ctx context.Context) {
+	now := time.Now()
+	subscriptions, err := b.DB.GetLeasedSubscriptions(b.Cfg.ReplicaID, now)
+	if err != nil {
+		log.Error("error getting subscriptions:", err.Error())
+		return
+	}
+
+	var (
+		listings   []*listing
+		combinable []*listing
+	)
+	for _, subs := range groupSubscriptions(subscriptions) {
+		if !isDue(subs, now) {
+			continue
+		}
+		l := b.newListing(subs)
+		if l == nil {
+			continue
+		}
+		if b.isCombinable(l) {
+			combinable = append(combinable, l)
+			continue
+		}
+		listings = append(listings, l)
+	}
+	log.Debugf("checking %d subreddit listings and %d combinable listings for %d subscriptions", len(listings), len(combinable), len(subscriptions))
+
+	// check the most overdue listings first in case we can't keep up
+	sort.SliceStable(listings, func(i, j int) bool {
+		return listings[i].nextCheck.Before(listings[j].nextCheck)
+	})
+
+	var requests int
+	pace := func(start time.Time) bool {
+		requests++
+		waitTime := b.targetTime() - time.Now().Sub(start)
+		if waitTime > 0 {
+			log.Debugf("waiting %s before checking next subreddit", waitTime.String())
+			return sleep(ctx, waitTime)
+		}
+		return ctx.Err() == nil
+	}
+	// waitForReddit pauses polling while the circuit breaker of the reddit client is open
+	waitForReddit := func() bool {
+		if waitTime := b.Reddit.Unavailable(); waitTime > 0 {
+			log.Warnf("pausing polling for %s while reddit is unavailable", waitTime.Round(time.Second))
+			return sleep(ctx, waitTime)
+		}
+		return true
+	}
+
+	for i := 0; i < len(combinable); i += b.Cfg.Reddit.MaxCombinedSubreddits {
+		end := i + b.Cfg.Reddit.MaxCombinedSubreddits
+		if end > len(combinable) {
+			end = len(combinable)
+		}
+		if !waitForReddit() {
+			return
+		}
+		start := time.Now()
+		listings = append(listings, b.checkCombinedListings(ctx, combinable[i:end])...)
+		if !pace(start) {
+			return
+		}
+	}
+
+	for _, l := range listings {
+		if !waitForReddit() {
+			return
+		}
+		start := time.Now()
+		b.checkListing(ctx, l)
+		if !pace(start) {
+			return
+		}
+	}
+
+	b.removeSeenPosts()
+
+	duration := time.Now().Sub(now)
+	if duration > time.Duration(requests)*b.targetTime() {
+		log.Debugf("took %s too long to check %d subreddit listings", duration.String(), requests)
+	}
+}
+
+// groupSubscriptions groups subscriptions which share the same subreddit listing, so it only has to be fetched once.
+func groupSubscriptions(subs []Subscription) [][]Subscription {
+	var (
+		groups  [][]Subscription
+		indexes = map[string]int{}
+	)
+	for _, sub := range subs {
+		key := listingKey(sub)
+		i, ok := indexes[key]
+		if !ok {
+			i = len(groups)
+			indexes[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], sub)
+	}
+	return groups
+}
+
+func listingKey(sub Subscription) string {
+	key := strings.ToLower(sub.Subreddit) + "/" + sub.Type
+	if hasTimeWindow(sub.Type) {
+		key += "/" + sub.TimeWindow
+	}
+	return key
+}
+
+// isDue returns whether any of the subscriptions sharing a listing is due to be checked.
+func isDue(subs []Subscription, now time.Time) bool {
+	for _, sub := range subs {
+		if !sub.NextCheck.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInterval returns how long to wait between checks of a subreddit which gets postRate posts per hour.
+// The interval is the expected time between two posts, bounded by reddit.min_check_interval and reddit.max_check_interval.
+func (b *Bot) checkInterval(postRate float64) time.Duration {
+	interval := b.Cfg.Reddit.MaxCheckInterval
+	if postRate > 0 {
+		interval = time.Duration(float64(time.Hour) / postRate)
+	}
+	if interval < b.Cfg.Reddit.MinCheckInterval {
+		return b.Cfg.Reddit.MinCheckInterval
+	}
+	if interval > b.Cfg.Reddit.MaxCheckInterval {
+		return b.Cfg.Reddit.MaxCheckInterval
+	}
+	return interval
+}
+
+// postRateSmoothing is the weight of the latest check when updating the post rate of a subscription.
+const postRateSmoothing = 0.3
+
+// scheduleSubscription learns the post rate of a subscription from a check which found posts new posts and schedules its next check.
+func (b *Bot) scheduleSubscription(sub Subscription, now time.Time, posts int) {
+	postRate := sub.PostRate
+	if sub.LastCheck.Unix() > 0 && now.After(sub.LastCheck) {
+		observed := float64(posts) / now.Sub(sub.LastCheck).Hours()
+		postRate = postRateSmoothing*observed + (1-postRateSmoothing)*postRate
+	}
+
+	nextCheck := now.Add(b.checkInterval(postRate))
+	log.Debugf("next check for webhook %s at %s with %.2f posts per hour", sub.WebhookID, nextCheck, postRate)
+	if err := b.DB.UpdateSubscriptionSchedule(sub.WebhookID, now, nextCheck, postRate); err != nil {
+		log.Errorf("error updating schedule for webhook %s: %s", sub.WebhookID, err.Error())
+	}
+}
+
+// retrySubscription schedules the next check of a subscription whose check failed without touching its post rate.
+func (b *Bot) retrySubscription(sub Subscription, now time.Time) {
+	if err := b.DB.UpdateSubscriptionSchedule(sub.WebhookID, sub.LastCheck, now.Add(b.Cfg.Reddit.MinCheckInterval), sub.PostRate); err != nil {
+		log.Errorf("error updating schedule for webhook %s: %s", sub.WebhookID, err.Error())
+	}
+}
+
+// removeSeenPosts forgets the posts seen longer than reddit.seen_post_retention ago.
+// Top and controversial subscriptions keep them at least as long as their time window and forever for all time.
+func (b *Bot) removeSeenPosts() {
+	now := time.Now()
+	removed, err := b.DB.RemoveSeenPostsBefore(now.Add(-b.Cfg.Reddit.SeenPostRetention))
+	if err != nil {
+		log.Error("error removing old seen posts:", err.Error())
+		return
+	}
+	for timeWindow, retention := range timeWindowDurations {
+		if retention == 0 {
+			continue
+		}
+		if retention < b.Cfg.Reddit.SeenPostRetention {
+			retention = b.Cfg.Reddit.SeenPostRetention
+		}
+		windowRemoved, err := b.DB.RemoveTimeWindowSeenPostsBefore(timeWindow, now.Add(-retention))
+		if err != nil {
+			log.Errorf("error removing old seen posts of time window %s: %s", timeWindow, err.Error())
+			continue
+		}
+		removed += windowRemoved
+	}
+	if removed > 0 {
+		log.Debugf("removed %d old seen posts", removed)
+	}
+}
+
+// seenPostsClockMargin is how long before the cutoff of a subscription its seen posts are loaded
+const seenPostsClockMargin = time.Hour
+
+// postsCutoff returns the time before which posts are not delivered to a subscription.
+// "new" subscriptions use their last post, top and controversial subscriptions the start of their time window.
+// Other ranked subscriptions can't rely on either and instead use the seen post retention.
+func (b *Bot) postsCutoff(sub Subscription) time.Time {
+	if sub.Type == "new" {
+		return sub.LastPost
+	}
+	if hasTimeWindow(sub.Type) {
+		if window := timeWindowDurations[sub.TimeWindow]; window > 0 {
+			return time.Now().Add(-window)
+		}
+		return time.Time{}
+	}
+
+	cutoff := time.Now().Add(-b.Cfg.Reddit.SeenPostRetention)
+	if sub.LastPost.After(cutoff) {
+		return sub.LastPost
+	}
+	return cutoff
+}
+
+// subscriptionCursor is the position of a subscription in a subreddit listing.
+type subscriptionCursor struct {
+	sub    Subscription
+	cutoff time.Time
+	seen   map[string]struct{}
+	// seed is whether the posts are only remembered, so a new time window doesn't send all posts which already rank in it
+	seed bool
+}
+
+func (c subscriptionCursor) wants(post RedditPost) bool {
+	if !time.Unix(int64(post.CreatedUtc), 0).After(c.cutoff) {
+		return false
+	}
+	_, ok := c.seen[post.Name]
+	return !ok
+}
+
+// listing is a subreddit listing shared by one or more subscriptions.
+type listing struct {
+	subreddit  string
+	fetchType  string
+	timeWindow string
+	cursors    []subscriptionCursor
+	// until is the oldest cutoff of all cursors
+	until time.Time
+	// nextCheck is the earliest next check of all cursors
+	nextCheck time.Time
+	// postRate is the highest post rate of all cursors
+	postRate float64
+}
+
+func (l *listing) wants(post RedditPost) bool {
+	for _, cursor := range l.cursors {
+		if cursor.wants(post) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bot) newListing(subs []Subscription) *listing {
+	var l *listing
+	for _, sub := range subs {
+		cutoff := b.postsCutoff(sub)
+		// posts after the cutoff can only have been seen after it, the margin covers the clocks of Reddit and the bot being apart
+		seenSince := cutoff
+		if !seenSince.IsZero() {
+			seenSince = seenSince.Add(-seenPostsClockMargin)
+		}
+		seen, err := b.DB.GetSeenPosts(sub.WebhookID, seenSince)
+		if err != nil {
+			log.Errorf("error getting seen posts for webhook %s: %s", sub.WebhookID, err.Error())
+			continue
+		}
+
+		if l == nil {
+			l = &listing{
+				subreddit:  sub.Subreddit,
+				fetchType:  sub.Type,
+				timeWindow: sub.TimeWindow,
+				until:      cutoff,
+				nextCheck:  sub.NextCheck,
+				postRate:   sub.PostRate,
+			}
+		}
+		if cutoff.Before(l.until) {
+			l.until = cutoff
+		}
+		if sub.NextCheck.Before(l.nextCheck) {
+			l.nextCheck = sub.NextCheck
+		}
+		if sub.PostRate > l.postRate {
+			l.postRate = sub.PostRate
+		}
+		l.cursors = append(l.cursors, subscriptionCursor{
+			sub:    sub,
+			cutoff: cutoff,
+			seen:   seen,
+			// the last post of top and controversial subscriptions is when they started using their time window
+			seed: hasTimeWindow(sub.Type) && sub.LastCheck.Before(sub.LastPost),
+		})
+	}
+	return l
+}
+
+// isCombinable returns whether a listing can be fetched together with other listings.
+// Only "new" listings which are expected to have few posts per check are combined, busy ones are fetched on their own.
+func (b *Bot) isCombinable(l *listing) bool {
+	if b.Cfg.Reddit.MaxCombinedSubreddits <= 1 || l.fetchType != "new" {
+		return false
+	}
+	// suspended subreddits could fail the whole combined request
+	for _, cursor := range l.cursors {
+		if cursor.sub.Suspended {
+			return false
+		}
+	}
+	expectedPosts := l.postRate * b.checkInterval(l.postRate).Hours()
+	return expectedPosts <= float64(postsPerPage/b.Cfg.Reddit.MaxCombinedSubreddits)
+}
+
+func (b *Bot) checkListing(ctx context.Context, l *listing) {
+	posts, err := b.Reddit.GetPostsUntil(ctx, l.subreddit, l.fetchType, l.timeWindow, l.until, l.wants, b.Cfg.Reddit.MaxPages)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("error getting posts for subreddit %s: %s", l.subreddit, err.Error())
+		now := time.Now()
+		for _, cursor := range l.cursors {
+			if errors.Is(err, ErrSubredditNotFound) || errors.Is(err, ErrSubredditForbidden) {
+				b.suspendSubscription(cursor.sub, now, err)
+				continue
+			}
+			b.retrySubscription(cursor.sub, now)
+		}
+		return
+	}
+	log.Debugf("got %d posts for subreddit %s after: %s\n", len(posts), l.subreddit, l.until)
+
+	b.deliverListing(ctx, l, posts)
+}
+
+// checkCombinedListings fetches the first page of the combined "new" listing of all subreddits and splits it by subreddit.
+// It returns the listings whose backlog is not covered by the combined page and need to be checked on their own.
+func (b *Bot) checkCombinedListings(ctx context.Context, listings []*listing) []*listing {
+	subreddits := make([]string, len(listings))
+	for i, l := range listings {
+		subreddits[i] = l.subreddit
+	}
+
+	posts, after, err := b.Reddit.GetCombinedPosts(ctx, subreddits, "new")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Errorf("error getting posts for combined subreddits %s: %s", strings.Join(subreddits, "+"), err.Error())
+		return listings
+	}
+
+	// when there are more pages, the combined page only covers posts newer than its oldest post
+	var oldest time.Time
+	if after != "" && len(posts) > 0 {
+		oldest = time.Unix(int64(posts[len(posts)-1].CreatedUtc), 0)
+	}
+
+	postsBySubreddit := map[string][]RedditPost{}
+	for _, post := range posts {
+		subreddit := strings.ToLower(strings.TrimPrefix(post.SubredditNamePrefixed, "r/"))
+		postsBySubreddit[subreddit] = append(postsBySubreddit[subreddit], post)
+	}
+
+	var fallback []*listing
+	for _, l := range listings {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if oldest.After(l.until) {
+			fallback = append(fallback, l)
+			continue
+		}
+
+		var subredditPosts []RedditPost
+		for _, post := range postsBySubreddit[strings.ToLower(l.subreddit)] {
+			if l.wants(post) {
+				subredditPosts = append(subredditPosts, post)
+			}
+		}
+		log.Debugf("got %d posts for subreddit %s from combined listing after: %s\n", len(subredditPosts), l.subreddit, l.until)
+
+		b.deliverListing(ctx, l, subredditPosts)
+	}
+
+	if len(fallback) > 0 {
+		log.Debugf("combined listing did not cover %d subreddits, checking them on their own", len(fallback))
+	}
+	return fallback
+}
+
+// deliverListing sends the posts of a listing to all of its subscriptions and schedules their next check.
+// Once ctx is done, the remaining subscriptions are left for the next check.
+func (b *Bot) deliverListing(ctx context.Context, l *listing, posts []RedditPost) {
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].CreatedUtc < posts[j].CreatedUtc
+	})
+
+	now := time.Now()
+	for _, cursor := range l.cursors {
+		if ctx.Err() != nil {
+			return
+		}
+		if cursor.sub.Suspended {
+			b.resumeSubscription(cursor.sub)
+		}
+		b.scheduleSubscription(cursor.sub, now, cursor.newPosts(posts))
+		b.checkSubscription(ctx, cursor, posts)
+	}
+}
+
+// newPosts returns how many posts of a listing the subscription didn't see before, busy listings are checked more often.
+// Posts held back by the thresholds are only counted once they reach them, so they aren't counted again with every check.
+// The posts remembered when a time window starts don't count either.
+func (c subscriptionCursor) newPosts(posts []RedditPost) int {
+	if c.seed {
+		return 0
+	}
+	var count int
+	for _, post := range posts {
+		if c.wants(post) && c.sub.meetsThresholds(post) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkSubscription adds the posts a subscription wants to its outbox.
+// The cursor of the subscription only moves once the outbox delivered the posts.
+func (b *Bot) checkSubscription(ctx context.Context, cursor subscriptionCursor, posts []RedditPost) {
+	sub := cursor.sub
+	if cursor.seed {
+		var seeded int
+		for _, post := range posts {
+			if !cursor.wants(post) {
+				continue
+			}
+			if err := b.DB.AddSeenPost(sub.WebhookID, post.Name); err != nil {
+				log.Errorf("error adding seen post %s for webhook %s: %s", post.Name, sub.WebhookID, err.Error())
+				continue
+			}
+			seeded++
+		}
+		log.Debugf("remembered %d posts already ranking in the %s time window of webhook %s", seeded, sub.TimeWindow, sub.WebhookID)
+		return
+	}
+	// only look up the channel when there is a NSFW post
+	var nsfw bool
+	if sub.AllowNSFW {
+		for _, post := range posts {
+			if !post.Over18 || !cursor.wants(post) {
+				continue
+			}
+			var err error
+			if nsfw, err = b.isChannelNSFW(ctx, sub.ChannelID); err != nil {
+				// leave all posts for the next check, the cursor would move past the NSFW ones otherwise
+				log.Errorf("error getting channel %s of webhook %s: %s", sub.ChannelID, sub.WebhookID, err.Error())
+				return
+			}
+			break
+		}
+	}
+	filter, err := b.subscriptionFilter(sub, nsfw)
+	if err != nil {
+		log.Errorf("error compiling filters of webhook %s: %s", sub.WebhookID, err.Error())
+		return
+	}
+	var held int
+	for _, post := range posts {
+		if !cursor.wants(post) {
+			continue
+		}
+		if !filter.allows(post) {
+			b.skipPost(sub, post)
+			continue
+		}
+		// posts below the thresholds are checked again until they reach them or are older than the cutoff
+		if !sub.meetsThresholds(post) {
+			held++
+			continue
+		}
+		if err := b.enqueuePost(sub, post); errors.Is(err, ErrLeaseLost) {
+			log.Debugf("stopped checking webhook %s, it's leased by another replica", sub.WebhookID)
+			return
+		} else if err != nil {
+			log.Errorf("error adding post %s to outbox of webhook %s: %s", post.Name, sub.WebhookID, err.Error())
+			return
+		}
+	}
+	if held > 0 {
+		log.Debugf("holding back %d posts below the thresholds of webhook %s", held, sub.WebhookID)
+	}
+}
+
+// skipPost moves the cursor of a subscription past a post its filters don't allow, so it isn't checked again.
+func (b *Bot) skipPost(sub Subscription, post RedditPost) {
+	if err := b.DB.AddSeenPost(sub.WebhookID, post.Name); err != nil {
+		log.Errorf("error adding seen post %s for webhook %s: %s", post.Name, sub.WebhookID, err.Error())
+		return
+	}
+	created := time.Unix(int64(post.CreatedUtc), 0)
+	if sub.Type == "new" && created.After(sub.LastPost) {
+		if err := b.DB.UpdateSubscriptionLastPost(sub.WebhookID, created); err != nil {
+			log.Errorf("error updating last post for webhook %s: %s", sub.WebhookID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package redditbot
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/log"
+)
+
+type requestPriority int
+
+const (
+	// priorityBackground is used for polling subreddits, it leaves some requests of each window to interactive requests.
+	priorityBackground requestPriority = iota
+	// priorityInteractive is used for requests a user is waiting for, like checking a subreddit in /reddit add.
+	priorityInteractive
+)
+
+// reserved returns how many requests of the current window are kept for requests with a higher priority.
+func (p requestPriority) reserved() int {
+	if p == priorityInteractive {
+		return 1
+	}
+	return 10
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		changed: make(chan struct{}),
+	}
+}
+
+// rateLimiter keeps track of the X-Ratelimit headers Reddit sends.
+// Requests wait for a free slot without holding the lock, so requests with a higher priority can pass waiting ones.
+type rateLimiter struct {
+	mu        sync.Mutex
+	used      int
+	remaining int
+	reset     time.Time
+	// changed is closed and replaced whenever the rate limit is updated
+	changed chan struct{}
+}
+
+// Wait blocks until a request with the given priority may be sent or ctx is done and returns how long it waited.
+func (l *rateLimiter) Wait(ctx context.Context, priority requestPriority) (time.Duration, error) {
+	start := time.Now()
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		if !now.Before(l.reset) || l.remaining > priority.reserved() {
+			l.remaining--
+			l.mu.Unlock()
+			return time.Now().Sub(start), nil
+		}
+		wait := l.reset.Sub(now)
+		changed := l.changed
+		l.mu.Unlock()
+
+		log.Debugf("rate limited, waiting up to %s", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Now().Sub(start), ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Update updates the rate limit from the headers of a response to a request which was sent at sent.
+func (l *rateLimiter) Update(header http.Header, sent time.Time) (used int, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, err := strconv.ParseFloat(header.Get("X-Ratelimit-Used"), 64); err == nil {
+		l.used = int(v)
+	} else {
+		log.Error("error parsing x-ratelimit-used:", err.Error())
+		l.used++
+	}
+	if v, err := strconv.ParseFloat(header.Get("X-Ratelimit-Remaining"), 64); err == nil {
+		l.remaining = int(v)
+	} else {
+		log.Error("error parsing x-ratelimit-remaining:", err.Error())
+	}
+	if v, err := strconv.ParseFloat(header.Get("X-Ratelimit-Reset"), 64); err == nil {
+		l.reset = sent.Add(time.Duration(v * float64(time.Second)))
+	} else {
+		log.Error("error parsing x-ratelimit-reset:", err.Error())
+	}
+	log.Debugf("rate limit: used: %d, remaining: %d, reset: %s\n", l.used, l.remaining, l.reset.Format(time.RFC3339))
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	return l.used, l.remaining, l.reset
+}
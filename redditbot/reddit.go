@@ -2,9 +2,12 @@ package redditbot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,49 +17,110 @@ import (
 	"golang.org/x/oauth2"
 )
 
-func NewReddit(cfg RedditConfig) (*Reddit, error) {
+var ErrRedditUnavailable = errors.New("reddit is unavailable")
+
+// ThrottledError is returned when Reddit responds with 429 Too Many Requests.
+type ThrottledError struct {
+	// RetryAfter is how long Reddit asked us to wait, it's 0 when no Retry-After header was sent
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("throttled by reddit, retry after %s", e.RetryAfter)
+	}
+	return "throttled by reddit"
+}
+
+// ServerError is returned when Reddit responds with a 5xx status code.
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("reddit server error: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// isTransient returns whether a request which failed with err is worth retrying.
+func isTransient(err error) bool {
+	var (
+		throttledErr *ThrottledError
+		serverErr    *ServerError
+		netErr       net.Error
+	)
+	return errors.As(err, &throttledErr) || errors.As(err, &serverErr) || errors.As(err, &netErr)
+}
+
+// postsPerPage is the maximum number of posts Reddit returns per listing page.
+const postsPerPage = 100
+
+func NewReddit(ctx context.Context, cfg RedditConfig) (*Reddit, error) {
 	reddit := &Reddit{
 		config: &oauth2.Config{
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
 			Endpoint: oauth2.Endpoint{
-				TokenURL:  "https://www.reddit.com/api/v1/access_token",
+				TokenURL:  cfg.TokenURL,
 				AuthStyle: oauth2.AuthStyleInHeader,
 			},
 		},
 		client: &http.Client{
+			Transport: &userAgentTransport{
+				userAgent: cfg.UserAgent,
+				transport: cfg.Transport,
+			},
 			Timeout: time.Second * 10,
 		},
+		apiURL:    strings.TrimSuffix(cfg.APIURL, "/"),
+		rateLimit: newRateLimiter(),
+		breaker:   newCircuitBreaker(),
 	}
 
-	if _, err := reddit.getToken(); err != nil {
+	if _, err := reddit.getToken(ctx); err != nil {
 		return nil, err
 	}
 
 	return reddit, nil
 }
 
-type rateLimit struct {
-	used      int
-	remaining int
-	reset     time.Time
+// userAgentTransport sets the User-Agent header on all requests, including the token exchange.
+type userAgentTransport struct {
+	userAgent string
+	transport http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	rq = rq.Clone(rq.Context())
+	rq.Header.Set("User-Agent", t.userAgent)
+
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(rq)
 }
 
 type Reddit struct {
 	config *oauth2.Config
 	client *http.Client
+	apiURL string
 
-	rateLimit rateLimit
+	rateLimit *rateLimiter
+	breaker   *circuitBreaker
 	token     *oauth2.Token
 	mu        sync.Mutex
 }
 
-func (r *Reddit) getToken() (*oauth2.Token, error) {
+func (r *Reddit) getToken(ctx context.Context) (*oauth2.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.token.Valid() {
 		return r.token, nil
 	}
 
-	token, err := r.config.Exchange(context.Background(), "", oauth2.SetAuthURLParam("grant_type", "client_credentials"))
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, r.client)
+	token, err := r.config.Exchange(ctx, "", oauth2.SetAuthURLParam("grant_type", "client_credentials"))
 	if err != nil {
 		return nil, fmt.Errorf("error exchanging token: %w", err)
 	}
@@ -66,98 +130,153 @@ func (r *Reddit) getToken() (*oauth2.Token, error) {
 	return token, nil
 }
 
-func (r *Reddit) do(rq *http.Request, important bool) (*http.Response, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+const (
+	// maxRetries is how often a request which failed with a transient error is retried
+	maxRetries = 3
+	// retryBaseDelay is the delay before the first retry, it doubles with every retry
+	retryBaseDelay = time.Second
+	// retryMaxDelay caps the delay between retries, Retry-After headers can exceed it
+	retryMaxDelay = 30 * time.Second
+)
 
-	log.Debugf("rate limit: used: %d, remaining: %d, reset: %s\n", r.rateLimit.used, r.rateLimit.remaining, r.rateLimit.reset.Format(time.RFC3339))
+// do sends a request to Reddit, transient errors are retried with a jittered exponential backoff.
+// Requests fail with ErrRedditUnavailable without being sent while the circuit breaker is open.
+// Only server and network errors open the circuit breaker, throttled requests just wait for the Retry-After.
+func (r *Reddit) do(rq *http.Request, priority requestPriority) (*http.Response, error) {
+	if wait := r.breaker.OpenFor(); wait > 0 {
+		return nil, fmt.Errorf("%w, retrying in %s", ErrRedditUnavailable, wait.Round(time.Second))
+	}
 
-	now := time.Now()
-	limit := 10
-	if important {
-		limit = 1
+	for attempt := 0; ; attempt++ {
+		rs, err := r.doOnce(rq, priority)
+		if err == nil {
+			r.breaker.Success()
+			return rs, nil
+		}
+		if rq.Context().Err() != nil || !isTransient(err) {
+			return nil, err
+		}
+
+		var throttledErr *ThrottledError
+		throttled := errors.As(err, &throttledErr)
+		if attempt >= maxRetries {
+			if !throttled {
+				r.breaker.Failure()
+			}
+			return nil, err
+		}
+
+		wait := backoff(attempt, retryBaseDelay, retryMaxDelay)
+		if throttled && throttledErr.RetryAfter > wait {
+			wait = throttledErr.RetryAfter
+		}
+
+		log.Debugf("retrying request to %s in %s: %s", rq.URL.Path, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-rq.Context().Done():
+			timer.Stop()
+			return nil, rq.Context().Err()
+		case <-timer.C:
+		}
 	}
-	var sleep time.Duration
-	if r.rateLimit.remaining <= limit && now.Before(r.rateLimit.reset) {
-		sleep = r.rateLimit.reset.Sub(now)
-		time.Sleep(sleep)
+}
+
+func (r *Reddit) doOnce(rq *http.Request, priority requestPriority) (*http.Response, error) {
+	sleep, err := r.rateLimit.Wait(rq.Context(), priority)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := r.getToken()
+	token, err := r.getToken(rq.Context())
 	if err != nil {
 		return nil, err
 	}
 
 	rq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
-	rq.Header.Set("User-Agent", "discord:com.github.topi314.reddit-discord-bot:1.0.0 (by /u/TobiDragneel)")
 
+	now := time.Now()
 	rs, err := r.client.Do(rq)
 	if err != nil {
 		return nil, fmt.Errorf("error doing request: %w", err)
 	}
 
-	headers := rs.Header
-	used, err := strconv.ParseFloat(headers.Get("X-Ratelimit-Used"), 64)
-	if err != nil {
-		log.Error("error parsing x-ratelimit-used:", err.Error())
-		used = float64(r.rateLimit.used + 1)
-	}
-	remaining, err := strconv.ParseFloat(headers.Get("X-Ratelimit-Remaining"), 64)
-	if err != nil {
-		log.Error("error parsing x-ratelimit-remaining:", err.Error())
-		remaining = float64(r.rateLimit.remaining - 1)
-	}
-	reset, err := strconv.ParseFloat(headers.Get("X-Ratelimit-Reset"), 64)
-	if err != nil {
-		log.Error("error parsing x-ratelimit-reset:", err.Error())
-		reset = float64(r.rateLimit.reset.Unix())
-	}
-
-	r.rateLimit = rateLimit{
-		used:      int(used),
-		remaining: int(remaining),
-		reset:     now.Add(time.Second * time.Duration(reset)),
-	}
+	used, remaining, reset := r.rateLimit.Update(rs.Header, now)
 
 	redditRequests.With(prometheus.Labels{
 		"path":      rq.URL.Path,
 		"method":    rq.Method,
 		"status":    strconv.Itoa(rs.StatusCode),
-		"important": strconv.FormatBool(important),
+		"important": strconv.FormatBool(priority == priorityInteractive),
 		"sleep":     strconv.FormatInt(int64(sleep), 10),
-		"used":      strconv.Itoa(r.rateLimit.used),
-		"remaining": strconv.Itoa(r.rateLimit.remaining),
-		"reset":     strconv.FormatInt(r.rateLimit.reset.Unix(), 10),
+		"used":      strconv.Itoa(used),
+		"remaining": strconv.Itoa(remaining),
+		"reset":     strconv.FormatInt(reset.Unix(), 10),
 	}).Inc()
 
+	if rs.StatusCode == http.StatusTooManyRequests {
+		_ = rs.Body.Close()
+		return nil, &ThrottledError{RetryAfter: parseRetryAfter(rs.Header.Get("Retry-After"), now)}
+	}
+	if rs.StatusCode >= http.StatusInternalServerError {
+		_ = rs.Body.Close()
+		return nil, &ServerError{StatusCode: rs.StatusCode}
+	}
+
 	return rs, nil
 }
 
-func (r *Reddit) GetPostsUntil(subreddit string, fetchType string, until time.Time, maxPages int) ([]RedditPost, error) {
+// parseRetryAfter parses a Retry-After header which can either be in seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// GetPostsUntil returns the posts of a subreddit listing which were created after until and are wanted.
+// Since "new" listings are sorted by creation time, they stop at the first post created before until.
+// Ranked listings like "hot", "top" or "rising" are paged until a page contains no wanted posts or maxPages is reached.
+// timeWindow is only used by "top" and "controversial" listings.
+func (r *Reddit) GetPostsUntil(ctx context.Context, subreddit string, fetchType string, timeWindow string, until time.Time, wanted func(post RedditPost) bool, maxPages int) ([]RedditPost, error) {
 	var (
 		posts []RedditPost
 		after string
 		page  = 1
 	)
 	for {
-		newPosts, err := r.getPosts(subreddit, fetchType, after)
+		newPosts, nextAfter, err := r.getPosts(ctx, subreddit, fetchType, timeWindow, after, priorityBackground)
 		if err != nil {
 			return nil, err
 		}
 
+		var wantedPosts int
 		for i := range newPosts {
 			createdAt := time.Unix(int64(newPosts[i].CreatedUtc), 0)
 			if createdAt.Before(until) || createdAt.Equal(until) {
-				return posts, nil
+				if fetchType == "new" {
+					return posts, nil
+				}
+				continue
 			}
+			if !wanted(newPosts[i]) {
+				continue
+			}
+			wantedPosts++
 			posts = append(posts, newPosts[i])
 		}
 
-		if len(newPosts) == 0 {
+		if nextAfter == "" || (wantedPosts == 0 && fetchType != "new") {
 			return posts, nil
 		}
 
-		after = newPosts[len(newPosts)-1].Name
+		after = nextAfter
 
 		page++
 		if page > maxPages {
@@ -166,29 +285,115 @@ func (r *Reddit) GetPostsUntil(subreddit string, fetchType string, until time.Ti
 	}
 }
 
-func (r *Reddit) getPosts(subreddit string, fetchType string, after string) ([]RedditPost, error) {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/%s.json?raw_json=1&sr_detail=true&limit=100", subreddit, fetchType)
+// Unavailable returns how long requests to Reddit are paused because it seems to be down.
+func (r *Reddit) Unavailable() time.Duration {
+	return r.breaker.OpenFor()
+}
+
+// GetPosts returns the first page of a subreddit listing for a user who is waiting for it.
+func (r *Reddit) GetPosts(ctx context.Context, subreddit string, fetchType string, timeWindow string) ([]RedditPost, error) {
+	posts, _, err := r.getPosts(ctx, subreddit, fetchType, timeWindow, "", priorityInteractive)
+	return posts, err
+}
+
+// GetCombinedPosts returns the first page of the combined listing of multiple subreddits and the cursor of the next page.
+func (r *Reddit) GetCombinedPosts(ctx context.Context, subreddits []string, fetchType string) ([]RedditPost, string, error) {
+	return r.getPosts(ctx, strings.Join(subreddits, "+"), fetchType, "", "", priorityBackground)
+}
+
+// GetFlairs returns the distinct link flairs of the latest posts of a subreddit.
+func (r *Reddit) GetFlairs(ctx context.Context, subreddit string) ([]string, error) {
+	posts, _, err := r.getPosts(ctx, subreddit, "new", "", "", priorityInteractive)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		flairs []string
+		seen   = map[string]struct{}{}
+	)
+	for _, post := range posts {
+		flair := strings.TrimSpace(post.LinkFlairText)
+		if flair == "" {
+			continue
+		}
+		if _, ok := seen[strings.ToLower(flair)]; ok {
+			continue
+		}
+		seen[strings.ToLower(flair)] = struct{}{}
+		flairs = append(flairs, flair)
+	}
+	return flairs, nil
+}
+
+// timeWindowDurations are how far back the time windows of top and controversial listings go, the all time window has no limit.
+var timeWindowDurations = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 31 * 24 * time.Hour,
+	"year":  366 * 24 * time.Hour,
+	"all":   0,
+}
+
+// hasTimeWindow returns whether a listing ranks its posts within a time window.
+func hasTimeWindow(fetchType string) bool {
+	return fetchType == "top" || fetchType == "controversial"
+}
+
+func (r *Reddit) getPosts(ctx context.Context, subreddit string, fetchType string, timeWindow string, after string, priority requestPriority) ([]RedditPost, string, error) {
+	url := fmt.Sprintf("%s/r/%s/%s.json?raw_json=1&sr_detail=true&limit=%d", r.apiURL, subreddit, fetchType, postsPerPage)
+	if hasTimeWindow(fetchType) && timeWindow != "" {
+		url += fmt.Sprintf("&t=%s", timeWindow)
+	}
 	if after != "" {
 		url += fmt.Sprintf("&after=%s", after)
 	}
 	log.Debug("getting posts for url: ", url)
-	rq, err := http.NewRequest(http.MethodGet, url, nil)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	rs, err := r.do(rq, false)
+	rs, err := r.do(rq, priority)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rs.Body.Close()
 
 	if rs.StatusCode == http.StatusNotFound {
-		return nil, ErrSubredditNotFound
+		return nil, "", ErrSubredditNotFound
 	} else if rs.StatusCode == http.StatusForbidden {
-		return nil, ErrSubredditForbidden
+		return nil, "", ErrSubredditForbidden
 	}
 
+	var response RedditResponse[RedditListing[RedditPost]]
+	if err = json.NewDecoder(rs.Body).Decode(&response); err != nil {
+		return nil, "", err
+	}
+
+	posts := make([]RedditPost, 0, len(response.Data.Children))
+	for i := range response.Data.Children {
+		posts = append(posts, response.Data.Children[i].Data)
+	}
+
+	return posts, response.Data.After, nil
+}
+
+// GetPostsByName returns the current state of up to postsPerPage posts by their fullname (t3_...).
+func (r *Reddit) GetPostsByName(ctx context.Context, names []string) ([]RedditPost, error) {
+	url := fmt.Sprintf("%s/api/info.json?raw_json=1&sr_detail=true&id=%s", r.apiURL, strings.Join(names, ","))
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := r.do(rq, priorityBackground)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+
 	var response RedditResponse[RedditListing[RedditPost]]
 	if err = json.NewDecoder(rs.Body).Decode(&response); err != nil {
 		return nil, err
@@ -202,35 +407,41 @@ func (r *Reddit) getPosts(subreddit string, fetchType string, after string) ([]R
 	return posts, nil
 }
 
-func (r *Reddit) CheckSubreddit(subreddit string) error {
-	url := fmt.Sprintf("https://oauth.reddit.com/r/%s/about.json?raw_json=1", subreddit)
-	rq, err := http.NewRequest(http.MethodGet, url, nil)
+// CheckSubreddit returns the details of a subreddit or an error if it doesn't exist or can't be accessed.
+func (r *Reddit) CheckSubreddit(ctx context.Context, subreddit string) (*SubredditAbout, error) {
+	url := fmt.Sprintf("%s/r/%s/about.json?raw_json=1", r.apiURL, subreddit)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	rs, err := r.do(rq, true)
+	rs, err := r.do(rq, priorityInteractive)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rs.Body.Close()
 
 	if rs.StatusCode == http.StatusNotFound {
-		return ErrSubredditNotFound
+		return nil, ErrSubredditNotFound
 	} else if rs.StatusCode == http.StatusForbidden {
-		return ErrSubredditForbidden
+		return nil, ErrSubredditForbidden
 	}
 
-	var response RedditResponse[struct{}]
+	var response RedditResponse[SubredditAbout]
 	if err = json.NewDecoder(rs.Body).Decode(&response); err != nil {
-		return err
+		return nil, err
 	}
 
 	if response.Kind != "t5" {
-		return ErrSubredditNotFound
+		return nil, ErrSubredditNotFound
 	}
 
-	return nil
+	return &response.Data, nil
+}
+
+type SubredditAbout struct {
+	DisplayName string `json:"display_name"`
+	Over18      bool   `json:"over18"`
 }
 
 type RedditResponse[T any] struct {
@@ -240,23 +451,178 @@ type RedditResponse[T any] struct {
 
 type RedditListing[T any] struct {
 	Before   string `json:"before"`
+	After    string `json:"after"`
 	Children []struct {
 		Data T `json:"data"`
 	} `json:"children"`
 }
 
 type RedditPost struct {
-	Selftext              string          `json:"selftext"`
-	AuthorFullname        string          `json:"author_fullname"`
-	Title                 string          `json:"title"`
-	SubredditNamePrefixed string          `json:"subreddit_name_prefixed"`
-	ID                    string          `json:"id"`
-	Name                  string          `json:"name"`
-	Author                string          `json:"author"`
-	URL                   string          `json:"url"`
-	Permalink             string          `json:"permalink"`
-	CreatedUtc            float64         `json:"created_utc"`
-	SrDetail              SubredditDetail `json:"sr_detail"`
+	Selftext              string                   `json:"selftext"`
+	AuthorFullname        string                   `json:"author_fullname"`
+	Title                 string                   `json:"title"`
+	SubredditNamePrefixed string                   `json:"subreddit_name_prefixed"`
+	ID                    string                   `json:"id"`
+	Name                  string                   `json:"name"`
+	Author                string                   `json:"author"`
+	URL                   string                   `json:"url"`
+	Domain                string                   `json:"domain"`
+	Permalink             string                   `json:"permalink"`
+	CreatedUtc            float64                  `json:"created_utc"`
+	RemovedByCategory     string                   `json:"removed_by_category"`
+	Score                 int                      `json:"score"`
+	NumComments           int                      `json:"num_comments"`
+	UpvoteRatio           float64                  `json:"upvote_ratio"`
+	IsGallery             bool                     `json:"is_gallery"`
+	GalleryData           *GalleryData             `json:"gallery_data"`
+	MediaMetadata         map[string]MediaMetadata `json:"media_metadata"`
+	Preview               *Preview                 `json:"preview"`
+	SecureMedia           *SecureMedia             `json:"secure_media"`
+	Thumbnail             string                   `json:"thumbnail"`
+	IsSelf                bool                     `json:"is_self"`
+	IsVideo               bool                     `json:"is_video"`
+	PostHint              string                   `json:"post_hint"`
+	PollData              *PollData                `json:"poll_data"`
+	CrosspostParent       string                   `json:"crosspost_parent"`
+	LinkFlairText         string                   `json:"link_flair_text"`
+	Over18                bool                     `json:"over_18"`
+	Spoiler               bool                     `json:"spoiler"`
+	Distinguished         string                   `json:"distinguished"`
+	Stickied              bool                     `json:"stickied"`
+	SrDetail              SubredditDetail          `json:"sr_detail"`
+}
+
+// IsAnnouncement returns whether the post was distinguished or stickied by the moderators.
+func (p RedditPost) IsAnnouncement() bool {
+	return p.Distinguished == "moderator" || p.Stickied
+}
+
+// Removed returns whether the post was removed by moderators or deleted by its author.
+func (p RedditPost) Removed() bool {
+	return p.RemovedByCategory != "" || p.Author == "[deleted]"
+}
+
+// GalleryImages returns the image URLs of a gallery post in the order of the gallery.
+func (p RedditPost) GalleryImages() []string {
+	if !p.IsGallery || p.GalleryData == nil {
+		return nil
+	}
+
+	var images []string
+	for _, item := range p.GalleryData.Items {
+		media, ok := p.MediaMetadata[item.MediaID]
+		if !ok || media.Status != "valid" {
+			continue
+		}
+		if media.S.GIF != "" {
+			images = append(images, media.S.GIF)
+		} else if media.S.URL != "" {
+			images = append(images, media.S.URL)
+		}
+	}
+	return images
+}
+
+// PreviewImage returns the best image Reddit generated as preview for a post, animated previews are preferred.
+// It falls back to the thumbnail and returns an empty string if there is neither.
+func (p RedditPost) PreviewImage() string {
+	if p.Preview != nil && len(p.Preview.Images) > 0 {
+		image := p.Preview.Images[0]
+		if image.Variants.GIF != nil && image.Variants.GIF.Source.URL != "" {
+			return image.Variants.GIF.Source.URL
+		}
+		if image.Source.URL != "" {
+			return image.Source.URL
+		}
+	}
+	// the thumbnail can also be "self", "default", "nsfw", "spoiler" or "image"
+	if strings.HasPrefix(p.Thumbnail, "https://") {
+		return p.Thumbnail
+	}
+	return ""
+}
+
+// Video returns the video of a post which is hosted on v.redd.it or nil.
+func (p RedditPost) Video() *RedditVideo {
+	if p.SecureMedia == nil {
+		return nil
+	}
+	return p.SecureMedia.RedditVideo
+}
+
+// IsGIF returns whether a post is an animated image, Reddit converts uploaded GIFs to videos without sound.
+func (p RedditPost) IsGIF() bool {
+	if video := p.Video(); video != nil && video.IsGIF {
+		return true
+	}
+	if strings.HasSuffix(p.URL, ".gif") || strings.HasSuffix(p.URL, ".gifv") {
+		return true
+	}
+	return p.Preview != nil && len(p.Preview.Images) > 0 && p.Preview.Images[0].Variants.GIF != nil
+}
+
+type PollData struct {
+	TotalVoteCount int `json:"total_vote_count"`
+	Options        []struct {
+		Text string `json:"text"`
+	} `json:"options"`
+}
+
+type Preview struct {
+	Images []PreviewImage `json:"images"`
+}
+
+type PreviewImage struct {
+	Source   PreviewSource   `json:"source"`
+	Variants PreviewVariants `json:"variants"`
+}
+
+type PreviewVariants struct {
+	GIF *PreviewVariant `json:"gif"`
+	MP4 *PreviewVariant `json:"mp4"`
+}
+
+type PreviewVariant struct {
+	Source PreviewSource `json:"source"`
+}
+
+type PreviewSource struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type SecureMedia struct {
+	RedditVideo *RedditVideo `json:"reddit_video"`
+}
+
+// RedditVideo is a video hosted on v.redd.it. The fallback URL is an mp4 which plays without the HLS or DASH playlists.
+type RedditVideo struct {
+	FallbackURL string `json:"fallback_url"`
+	Duration    int    `json:"duration"`
+	IsGIF       bool   `json:"is_gif"`
+}
+
+type GalleryData struct {
+	Items []GalleryItem `json:"items"`
+}
+
+type GalleryItem struct {
+	MediaID string `json:"media_id"`
+	Caption string `json:"caption"`
+}
+
+// MediaMetadata describes an image of a gallery post.
+type MediaMetadata struct {
+	Status string      `json:"status"`
+	Type   string      `json:"e"`
+	S      MediaSource `json:"s"`
+}
+
+// MediaSource is the full size version of an image, animated images have a GIF instead of a URL.
+type MediaSource struct {
+	URL string `json:"u"`
+	GIF string `json:"gif"`
 }
 
 type SubredditDetail struct {
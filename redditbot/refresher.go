@@ -0,0 +1,198 @@
+package redditbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/json"
+	"github.com/disgoorg/log"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// maxRefreshEditsPerWebhook is the max number of messages of a webhook which are edited or deleted per refresh,
+// so refreshing doesn't keep the webhooks busy which new posts are delivered to.
+const maxRefreshEditsPerWebhook = 5
+
+// ListenRefresh checks recently delivered posts for edits, removals and new scores until ctx is done.
+func (b *Bot) ListenRefresh(ctx context.Context) {
+	if b.Cfg.Reddit.RefreshWindow <= 0 {
+		return
+	}
+	for {
+		if !sleep(ctx, b.Cfg.Reddit.RefreshInterval) {
+			log.Info("stopped refreshing posts")
+			return
+		}
+		b.refreshMessages(ctx)
+	}
+}
+
+// refreshMessages fetches the current state of all recently delivered posts in bulk and updates their messages.
+func (b *Bot) refreshMessages(ctx context.Context) {
+	now := time.Now()
+	since := now.Add(-b.Cfg.Reddit.RefreshWindow)
+	if removed, err := b.DB.RemoveDeliveredMessagesBefore(since); err != nil {
+		log.Error("error removing old messages:", err.Error())
+	} else if removed > 0 {
+		log.Debugf("removed %d old messages", removed)
+	}
+
+	messages, err := b.DB.GetRefreshableMessages(b.Cfg.ReplicaID, now, since)
+	if err != nil {
+		log.Error("error getting messages to refresh:", err.Error())
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	subs, err := b.DB.GetLeasedSubscriptions(b.Cfg.ReplicaID, now)
+	if err != nil {
+		log.Error("error getting subscriptions:", err.Error())
+		return
+	}
+	subsByWebhook := make(map[snowflake.ID]Subscription, len(subs))
+	for _, sub := range subs {
+		subsByWebhook[sub.WebhookID] = sub
+	}
+
+	// several subscriptions can share the same post, only fetch it once
+	var names []string
+	posts := map[string]RedditPost{}
+	for _, message := range messages {
+		if _, ok := posts[message.PostName]; ok {
+			continue
+		}
+		posts[message.PostName] = RedditPost{}
+		names = append(names, message.PostName)
+	}
+
+	for i := 0; i < len(names); i += postsPerPage {
+		end := i + postsPerPage
+		if end > len(names) {
+			end = len(names)
+		}
+		newPosts, err := b.Reddit.GetPostsByName(ctx, names[i:end])
+		if err != nil {
+			log.Error("error getting posts to refresh:", err.Error())
+			return
+		}
+		for _, post := range newPosts {
+			posts[post.Name] = post
+		}
+	}
+	log.Debugf("refreshing %d messages of %d posts", len(messages), len(names))
+
+	// removed posts go first, the other messages stay newest first
+	sort.SliceStable(messages, func(i, j int) bool {
+		return posts[messages[i].PostName].Removed() && !posts[messages[j].PostName].Removed()
+	})
+
+	edits := map[snowflake.ID]int{}
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			return
+		}
+		sub, ok := subsByWebhook[message.WebhookID]
+		if !ok || edits[sub.WebhookID] >= maxRefreshEditsPerWebhook {
+			continue
+		}
+		post := posts[message.PostName]
+		if post.Name == "" {
+			continue
+		}
+		if b.refreshMessage(sub, message, post) {
+			edits[sub.WebhookID]++
+		}
+	}
+}
+
+// refreshMessage re-renders the message of a post and updates it when anything changed, like the text or the score.
+// Removed posts are handled according to the subscription. It returns whether the message was sent to Discord.
+func (b *Bot) refreshMessage(sub Subscription, message DeliveredMessage, post RedditPost) bool {
+	if post.Removed() {
+		if sub.RemovedPosts == RemovedPostActionDelete {
+			b.deleteMessage(sub, message)
+			return true
+		}
+
+		if err := b.updateMessage(sub, message, b.renderRemovedPost(sub, post)); err != nil {
+			return true
+		}
+		if err := b.DB.MarkDeliveredMessageRemoved(sub.WebhookID, message.PostName); err != nil {
+			log.Errorf("error marking message of post %s for webhook %s as removed: %s", message.PostName, sub.WebhookID, err.Error())
+		}
+		return true
+	}
+
+	messageCreate := b.renderPost(sub, post)
+	payload, err := json.Marshal(messageCreate)
+	if err != nil {
+		log.Errorf("error rendering post %s for webhook %s: %s", message.PostName, sub.WebhookID, err.Error())
+		return false
+	}
+	if string(payload) == message.Payload {
+		return false
+	}
+
+	if err = b.updateMessage(sub, message, messageCreate); err != nil {
+		return true
+	}
+	if err = b.DB.UpdateDeliveredMessagePayload(sub.WebhookID, message.PostName, string(payload)); err != nil {
+		log.Errorf("error updating message of post %s for webhook %s: %s", message.PostName, sub.WebhookID, err.Error())
+	}
+	return true
+}
+
+func (b *Bot) updateMessage(sub Subscription, message DeliveredMessage, messageCreate discord.WebhookMessageCreate) error {
+	if b.Cfg.TestMode {
+		log.Debugf("updating message of post %s for webhook %d", message.PostName, sub.WebhookID)
+		return nil
+	}
+
+	// only send the parts the message is made of, the others would be cleared
+	var messageUpdate discord.WebhookMessageUpdate
+	if messageCreate.Content != "" {
+		messageUpdate.Content = &messageCreate.Content
+	}
+	if len(messageCreate.Embeds) > 0 {
+		messageUpdate.Embeds = &messageCreate.Embeds
+	}
+
+	_, err := b.Client.Rest().UpdateWebhookMessage(sub.WebhookID, sub.WebhookToken, message.MessageID, messageUpdate, 0)
+	if err != nil {
+		b.handleMessageError(sub, message, err)
+	}
+	return err
+}
+
+func (b *Bot) deleteMessage(sub Subscription, message DeliveredMessage) {
+	if b.Cfg.TestMode {
+		log.Debugf("deleting message of post %s for webhook %d", message.PostName, sub.WebhookID)
+	} else if err := b.Client.Rest().DeleteWebhookMessage(sub.WebhookID, sub.WebhookToken, message.MessageID, 0); err != nil {
+		b.handleMessageError(sub, message, err)
+		return
+	}
+
+	if err := b.DB.RemoveDeliveredMessage(sub.WebhookID, message.PostName); err != nil {
+		log.Errorf("error removing message of post %s for webhook %s: %s", message.PostName, sub.WebhookID, err.Error())
+	}
+}
+
+// handleMessageError handles a failed update of a message, messages which were deleted in Discord are forgotten.
+func (b *Bot) handleMessageError(sub Subscription, message DeliveredMessage, err error) {
+	var restError rest.Error
+	if !errors.As(err, &restError) || restError.Response == nil || restError.Response.StatusCode != http.StatusNotFound {
+		log.Errorf("error updating message of post %s for webhook %d: %s", message.PostName, sub.WebhookID, err.Error())
+		return
+	}
+
+	if err = b.DB.RemoveDeliveredMessage(sub.WebhookID, message.PostName); err != nil {
+		log.Errorf("error removing message of post %s for webhook %s: %s", message.PostName, sub.WebhookID, err.Error())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"html"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
@@ -51,7 +50,7 @@ func (b *Bot) RemoveSubscription(webhookID snowflake.ID, webhookToken string, er
 					Title:       "Error",
 					Timestamp:   json.Ptr(time.Now()),
 					Color:       RedditColor,
-					Description: fmt.Sprintf("An error occurred while trying to get posts from this subreddit: %s\nRemoving this webhook" + err.Error()),
+					Description: fmt.Sprintf("An error occurred while trying to get posts from this subreddit: %s\nRemoving this webhook", err.Error()),
 				},
 			},
 		}, false, 0)
@@ -79,6 +78,76 @@ func (b *Bot) RemoveSubscription(webhookID snowflake.ID, webhookToken string, er
 	return nil
 }
 
+// maxSuspendedCheckInterval caps how long to wait between checks of a suspended subscription.
+const maxSuspendedCheckInterval = 12 * time.Hour
+
+// suspendSubscription is called when the subreddit of a subscription can't be accessed.
+// Instead of removing the subscription right away, it's suspended and checked less often until the subreddit is back or the grace period is over.
+func (b *Bot) suspendSubscription(sub Subscription, now time.Time, err error) {
+	if sub.Suspended && now.Sub(sub.SuspendedSince) > b.Cfg.Reddit.SuspensionGracePeriod {
+		log.Infof("removing sub for webhook %s after being suspended since %s: %s", sub.WebhookID, sub.SuspendedSince, err.Error())
+		if rErr := b.RemoveSubscription(sub.WebhookID, sub.WebhookToken, err); rErr != nil {
+			log.Errorf("error removing sub for webhook %s: %s", sub.WebhookID, rErr.Error())
+		}
+		return
+	}
+
+	if !sub.Suspended {
+		log.Infof("suspending sub for webhook %s: %s", sub.WebhookID, err.Error())
+		sub.SuspendedSince = now
+		b.notifySubscription(sub, fmt.Sprintf("Can't get posts from this subreddit: %s\nPausing this subscription, it will resume once the subreddit is available again or be removed <t:%d:R>", err.Error(), sub.SuspendedSince.Add(b.Cfg.Reddit.SuspensionGracePeriod).Unix()))
+	}
+
+	failures := sub.Failures + 1
+	if err = b.DB.UpdateSubscriptionSuspension(sub.WebhookID, true, sub.SuspendedSince, failures, err.Error()); err != nil {
+		log.Errorf("error suspending sub for webhook %s: %s", sub.WebhookID, err.Error())
+		return
+	}
+
+	// back off exponentially starting at the max check interval
+	interval := b.Cfg.Reddit.MaxCheckInterval
+	for i := 1; i < failures && interval < maxSuspendedCheckInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxSuspendedCheckInterval {
+		interval = maxSuspendedCheckInterval
+	}
+	if err = b.DB.UpdateSubscriptionSchedule(sub.WebhookID, sub.LastCheck, now.Add(interval), sub.PostRate); err != nil {
+		log.Errorf("error updating schedule for webhook %s: %s", sub.WebhookID, err.Error())
+	}
+}
+
+// resumeSubscription is called when the subreddit of a suspended subscription can be accessed again.
+func (b *Bot) resumeSubscription(sub Subscription) {
+	log.Infof("resuming sub for webhook %s after being suspended since %s", sub.WebhookID, sub.SuspendedSince)
+	if err := b.DB.UpdateSubscriptionSuspension(sub.WebhookID, false, time.Time{}, 0, ""); err != nil {
+		log.Errorf("error resuming sub for webhook %s: %s", sub.WebhookID, err.Error())
+		return
+	}
+	b.notifySubscription(sub, "The subreddit is available again, resuming this subscription")
+}
+
+// notifySubscription sends a status message to the webhook of a subscription.
+func (b *Bot) notifySubscription(sub Subscription, message string) {
+	if b.Cfg.TestMode {
+		log.Debugf("sending notice to webhook %d: %s", sub.WebhookID, message)
+		return
+	}
+
+	if _, err := b.Client.Rest().CreateWebhookMessage(sub.WebhookID, sub.WebhookToken, discord.WebhookMessageCreate{
+		Embeds: []discord.Embed{
+			{
+				Title:       "r/" + sub.Subreddit,
+				Timestamp:   json.Ptr(time.Now()),
+				Color:       RedditColor,
+				Description: message,
+			},
+		},
+	}, false, 0); err != nil {
+		log.Errorf("error sending notice to webhook %d: %s", sub.WebhookID, err.Error())
+	}
+}
+
 func (b *Bot) RemoveSubscriptionByGuildSubreddit(guildID snowflake.ID, subreddit string, reason string) error {
 	sub, err := b.DB.RemoveSubscriptionByGuildSubreddit(guildID, subreddit)
 	if err != nil {
@@ -98,81 +167,14 @@ func (b *Bot) RemoveSubscriptionByGuildSubreddit(guildID snowflake.ID, subreddit
 	return nil
 }
 
-func (b *Bot) targetTime() time.Duration {
-	return time.Minute / time.Duration(b.Cfg.Reddit.RequestsPerMinute)
-}
-
-func (b *Bot) ListenSubreddits() {
-	for {
-		now := time.Now()
-		subscriptions, err := b.DB.GetAllSubscriptionIDs()
-		if err != nil {
-			log.Error("error getting subscriptions:", err.Error())
-			continue
-		}
-		log.Debugf("checking subreddits for %d subscriptions", len(subscriptions))
-
-		for i := range subscriptions {
-			subNow := time.Now()
-			sub, err := b.DB.GetSubscription(subscriptions[i])
-			if errors.Is(err, ErrSubscriptionNotFound) {
-				continue
-			} else if err != nil {
-				log.Errorf("error checking subscription for webhook %s: %s", subscriptions[i], err.Error())
-				continue
-			}
-
-			b.checkSubscription(*sub)
-
-			waitTime := b.targetTime() - time.Now().Sub(subNow)
-			if waitTime > 0 {
-				log.Debugf("waiting %s before checking next sub", waitTime.String())
-				<-time.After(waitTime)
-			}
-		}
-
-		duration := time.Now().Sub(now)
-		if duration > time.Duration(len(subscriptions))*b.targetTime() {
-			log.Debugf("took %s too long to check %d subreddits", duration.String(), len(subscriptions))
-		}
-
-		time.Sleep(5 * time.Second)
-	}
-}
-
-func (b *Bot) checkSubscription(sub Subscription) {
-	posts, err := b.Reddit.GetPostsUntil(sub.Subreddit, sub.Type, sub.LastPost, b.Cfg.Reddit.MaxPages)
-	if err != nil {
-		log.Errorf("error getting posts for subreddit %s: %s", sub.Subreddit, err.Error())
-		if errors.Is(err, ErrSubredditNotFound) || errors.Is(err, ErrSubredditForbidden) {
-			if err = b.RemoveSubscription(sub.WebhookID, sub.WebhookToken, err); err != nil {
-				log.Errorf("error removing sub for webhook %s: %s", sub.WebhookID, err.Error())
-			}
-		}
-		return
-	}
-	log.Debugf("got %d posts for subreddit %s before: %s\n", len(posts), sub.Subreddit, sub.LastPost)
-
-	for i := len(posts) - 1; i >= 0; i-- {
-		if !b.sendPost(sub, posts[i]) {
-			return
-		}
-	}
-
-	if len(posts) > 0 {
-		if err = b.DB.UpdateSubscriptionLastPost(sub.WebhookID, time.Unix(int64(posts[0].CreatedUtc), 0)); err != nil {
-			log.Errorf("error updating last post for webhook %s: %s", sub.WebhookID, err.Error())
-		}
-	}
-}
-
-func (b *Bot) sendPost(sub Subscription, post RedditPost) bool {
+// renderPost formats a post as webhook message according to the subscription format type.
+func (b *Bot) renderPost(sub Subscription, post RedditPost) discord.WebhookMessageCreate {
 	var webhookMessageCreate discord.WebhookMessageCreate
 	switch sub.FormatType {
 	case FormatTypeEmbed:
 		embed := discord.Embed{
-			Title:       cutString(post.Title, 256),
-			Description: cutString(html.UnescapeString(post.Selftext), 4069),
+			Title:       cutString(postLabel(post)+post.Title, 256),
+			Description: markSpoiler(post, cutString(html.UnescapeString(post.Selftext), 4069)),
 			URL:         "https://reddit.com" + post.Permalink,
 			Timestamp:   json.Ptr(time.Unix(int64(post.CreatedUtc), 0)),
 			Color:       RedditColor,
@@ -181,50 +183,154 @@ func (b *Bot) sendPost(sub Subscription, post RedditPost) bool {
 				URL:     "https://reddit.com/" + post.SubredditNamePrefixed,
 				IconURL: post.SrDetail.CommunityIcon,
 			},
+			Fields: []discord.EmbedField{
+				{
+					Name:   "Score",
+					Value:  strconv.Itoa(post.Score),
+					Inline: json.Ptr(true),
+				},
+				{
+					Name:   "Upvote Ratio",
+					Value:  fmt.Sprintf("%.0f%%", post.UpvoteRatio*100),
+					Inline: json.Ptr(true),
+				},
+				{
+					Name:   "Comments",
+					Value:  strconv.Itoa(post.NumComments),
+					Inline: json.Ptr(true),
+				},
+			},
 			Footer: &discord.EmbedFooter{
 				Text: "posted by " + post.Author,
 			},
 		}
-		if imageRegex.MatchString(post.URL) {
-			embed.Image = &discord.EmbedResource{
-				URL: post.URL,
+		if post.LinkFlairText != "" {
+			embed.Fields = append([]discord.EmbedField{{
+				Name:  "Flair",
+				Value: post.LinkFlairText,
+			}}, embed.Fields...)
+		}
+		if video := post.Video(); video != nil && video.FallbackURL != "" {
+			embed.Fields = append(embed.Fields, discord.EmbedField{
+				Name:  "Video",
+				Value: markSpoiler(post, fmt.Sprintf("[Play video](%s)", video.FallbackURL)),
+			})
+		}
+		images := postImages(post)
+		// embed images can't be hidden, so spoilers only link their images
+		if post.Spoiler && len(images) > 0 {
+			links := make([]string, len(images))
+			for i, image := range images {
+				links[i] = markSpoiler(post, image)
+			}
+			embed.Fields = append(embed.Fields, discord.EmbedField{
+				Name:  "Images",
+				Value: cutString(strings.Join(links, "\n"), 1024),
+			})
+			images = nil
+		}
+
+		embeds := []discord.Embed{embed}
+		// discord shows the images of embeds which share the same url in one embed
+		for i, image := range images {
+			if i == 0 {
+				embeds[0].Image = &discord.EmbedResource{
+					URL: image,
+				}
+				continue
 			}
+			embeds = append(embeds, discord.Embed{
+				URL: embed.URL,
+				Image: &discord.EmbedResource{
+					URL: image,
+				},
+			})
 		}
 
 		webhookMessageCreate = discord.WebhookMessageCreate{
-			Embeds: []discord.Embed{embed},
+			Embeds: embeds,
 		}
 	case FormatTypeText:
+		content := fmt.Sprintf("## %s[%s](https://reddit.com%s)\n", postLabel(post), post.Title, post.Permalink)
+		for _, image := range post.GalleryImages() {
+			content += markSpoiler(post, image) + "\n"
+		}
+		if video := post.Video(); video != nil && video.FallbackURL != "" {
+			content += markSpoiler(post, video.FallbackURL) + "\n"
+		}
+		text := quoteString(html.UnescapeString(post.Selftext))
+		if post.Spoiler && post.Selftext != "" {
+			// the spoiler has to start after the quote
+			text = "> ||" + strings.TrimPrefix(text, "> ") + "||"
+		}
 		webhookMessageCreate = discord.WebhookMessageCreate{
-			Content: fmt.Sprintf("## [%s](https://reddit.com%s)\n%s", post.Title, post.Permalink, cutString(quoteString(html.UnescapeString(post.Selftext)), 4000)),
+			Content: cutContent(content+text, maxContentLength),
 		}
 	}
 
-	postsSent.With(prometheus.Labels{
-		"subreddit":  sub.Subreddit,
-		"type":       sub.Type,
-		"webhook_id": strconv.FormatUint(uint64(sub.WebhookID), 10),
-		"guild_id":   strconv.FormatUint(uint64(sub.GuildID), 10),
-		"channel_id": strconv.FormatUint(uint64(sub.ChannelID), 10),
-	}).Inc()
+	return webhookMessageCreate
+}
 
-	if b.Cfg.TestMode {
-		log.Debugf("sending post to webhook %d: %s", sub.WebhookID, post.Title)
-		return true
+// renderRemovedPost formats a post which was removed from Reddit, the title is kept but its content is gone.
+func (b *Bot) renderRemovedPost(sub Subscription, post RedditPost) discord.WebhookMessageCreate {
+	webhookMessageCreate := b.renderPost(sub, post)
+	switch sub.FormatType {
+	case FormatTypeEmbed:
+		embed := webhookMessageCreate.Embeds[0]
+		embed.Title = cutString("[removed] "+post.Title, 256)
+		embed.Description = "*This post was removed from Reddit*"
+		embed.Image = nil
+		webhookMessageCreate.Embeds = []discord.Embed{embed}
+	case FormatTypeText:
+		webhookMessageCreate.Content = fmt.Sprintf("## ~~[%s](https://reddit.com%s)~~\n*This post was removed from Reddit*", post.Title, post.Permalink)
 	}
+	return webhookMessageCreate
+}
 
-	if _, err := b.Client.Rest().CreateWebhookMessage(sub.WebhookID, sub.WebhookToken, webhookMessageCreate, false, 0); err != nil {
-		var restError rest.Error
-		if errors.As(err, &restError) && restError.Response.StatusCode == http.StatusNotFound {
-			if err = b.RemoveSubscription(sub.WebhookID, sub.WebhookToken, nil); err != nil {
-				log.Errorf("error removing sub for webhook %s: %s", sub.WebhookID, err.Error())
-			}
-			return false
+// maxEmbedImages is the max number of images discord shows in one embed
+const maxEmbedImages = 4
+
+// postImages returns the images to show in the embed of a post.
+func postImages(post RedditPost) []string {
+	if images := post.GalleryImages(); len(images) > 0 {
+		if len(images) > maxEmbedImages {
+			images = images[:maxEmbedImages]
 		}
-		log.Errorf("error sending post to webhook %d: %s", sub.WebhookID, err.Error())
+		return images
+	}
+	if imageRegex.MatchString(post.URL) {
+		return []string{post.URL}
 	}
+	// videos and links to other sites like imgur or youtube only have a preview
+	if image := post.PreviewImage(); image != "" {
+		return []string{image}
+	}
+	return nil
+}
 
-	return true
+// postLabel returns a prefix for the title of a post which marks NSFW posts, spoilers, GIFs and videos.
+func postLabel(post RedditPost) string {
+	var label string
+	if post.Over18 {
+		label += "[NSFW] "
+	}
+	if post.Spoiler {
+		label += "[Spoiler] "
+	}
+	if post.IsGIF() {
+		label += "[GIF] "
+	} else if post.Video() != nil {
+		label += "[Video] "
+	}
+	return label
+}
+
+// markSpoiler hides str behind a spoiler if the post is marked as spoiler.
+func markSpoiler(post RedditPost, str string) string {
+	if !post.Spoiler || str == "" {
+		return str
+	}
+	return "||" + str + "||"
 }
 
 func cutString(str string, maxLen int) string {
@@ -235,6 +341,22 @@ func cutString(str string, maxLen int) string {
 	return string(runes)
 }
 
+// maxContentLength is the max length of the content of a discord message
+const maxContentLength = 2000
+
+// cutContent cuts the content of a message to maxLen and closes a spoiler which was cut in half.
+func cutContent(str string, maxLen int) string {
+	runes := []rune(str)
+	if len(runes) <= maxLen {
+		return str
+	}
+	content := string(runes[0:maxLen-3]) + "…"
+	if strings.Count(content, "||")%2 == 1 {
+		content += "||"
+	}
+	return content
+}
+
 func quoteString(str string) string {
 	return "> " + strings.ReplaceAll(str, "\n", "\n> ")
 }
@@ -1,12 +1,90 @@
 CREATE TABLE IF NOT EXISTS subscriptions
 (
-	subreddit     VARCHAR   NOT NULL,
-	type          VARCHAR   NOT NULL DEFAULT 'new',
-	format_type   VARCHAR   NOT NULL DEFAULT 'embed',
-	guild_id      BIGINT    NOT NULL,
-	channel_id    BIGINT    NOT NULL,
-	webhook_id    BIGINT    NOT NULL,
-	webhook_token VARCHAR   NOT NULL,
-	last_post     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	subreddit          VARCHAR          NOT NULL,
+	type               VARCHAR          NOT NULL DEFAULT 'new',
+	format_type        VARCHAR          NOT NULL DEFAULT 'embed',
+	guild_id           BIGINT           NOT NULL,
+	channel_id         BIGINT           NOT NULL,
+	webhook_id         BIGINT           NOT NULL,
+	webhook_token      VARCHAR          NOT NULL,
+	last_post          TIMESTAMP        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_check         TIMESTAMP        NOT NULL DEFAULT '1970-01-01 00:00:00',
+	next_check         TIMESTAMP        NOT NULL DEFAULT '1970-01-01 00:00:00',
+	post_rate          DOUBLE PRECISION NOT NULL DEFAULT 0,
+	suspended          BOOLEAN          NOT NULL DEFAULT FALSE,
+	suspended_since    TIMESTAMP        NOT NULL DEFAULT '1970-01-01 00:00:00',
+	failures           INT              NOT NULL DEFAULT 0,
+	last_error         VARCHAR          NOT NULL DEFAULT '',
+	lease_owner        VARCHAR          NOT NULL DEFAULT '',
+	lease_expires      TIMESTAMP        NOT NULL DEFAULT '1970-01-01 00:00:00',
+	removed_posts      VARCHAR          NOT NULL DEFAULT 'mark',
+	post_kinds         VARCHAR          NOT NULL DEFAULT '',
+	include_patterns   VARCHAR          NOT NULL DEFAULT '',
+	exclude_patterns   VARCHAR          NOT NULL DEFAULT '',
+	flair_allowlist    VARCHAR          NOT NULL DEFAULT '',
+	flair_denylist     VARCHAR          NOT NULL DEFAULT '',
+	allow_nsfw         BOOLEAN          NOT NULL DEFAULT TRUE,
+	min_score          INT              NOT NULL DEFAULT 0,
+	min_comments       INT              NOT NULL DEFAULT 0,
+	min_upvote_ratio   DOUBLE PRECISION NOT NULL DEFAULT 0,
+	time_window        VARCHAR          NOT NULL DEFAULT 'day',
+	author_allowlist   VARCHAR          NOT NULL DEFAULT '',
+	author_denylist    VARCHAR          NOT NULL DEFAULT '',
+	announcements_only BOOLEAN          NOT NULL DEFAULT FALSE,
+	domain_allowlist   VARCHAR          NOT NULL DEFAULT '',
+	domain_denylist    VARCHAR          NOT NULL DEFAULT '',
+	filter_expression  VARCHAR          NOT NULL DEFAULT '',
 	PRIMARY KEY (subreddit, guild_id)
-)
+);
+
+CREATE TABLE IF NOT EXISTS seen_posts
+(
+	webhook_id BIGINT    NOT NULL,
+	name       VARCHAR   NOT NULL,
+	seen_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (webhook_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS outbox
+(
+	webhook_id   BIGINT    NOT NULL,
+	post_name    VARCHAR   NOT NULL,
+	post_created TIMESTAMP NOT NULL,
+	payload      VARCHAR   NOT NULL,
+	attempts     INT       NOT NULL DEFAULT 0,
+	next_attempt TIMESTAMP NOT NULL,
+	last_error   VARCHAR   NOT NULL DEFAULT '',
+	created_at   TIMESTAMP NOT NULL,
+	PRIMARY KEY (webhook_id, post_name)
+);
+
+CREATE TABLE IF NOT EXISTS dead_letters
+(
+	webhook_id   BIGINT    NOT NULL,
+	post_name    VARCHAR   NOT NULL,
+	post_created TIMESTAMP NOT NULL,
+	payload      VARCHAR   NOT NULL,
+	attempts     INT       NOT NULL,
+	last_error   VARCHAR   NOT NULL,
+	created_at   TIMESTAMP NOT NULL,
+	failed_at    TIMESTAMP NOT NULL,
+	PRIMARY KEY (webhook_id, post_name)
+);
+
+CREATE TABLE IF NOT EXISTS messages
+(
+	webhook_id   BIGINT    NOT NULL,
+	post_name    VARCHAR   NOT NULL,
+	message_id   BIGINT    NOT NULL,
+	payload      VARCHAR   NOT NULL,
+	removed      BOOLEAN   NOT NULL DEFAULT FALSE,
+	delivered_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (webhook_id, post_name)
+);
+
+CREATE TABLE IF NOT EXISTS replicas
+(
+	id        VARCHAR   NOT NULL,
+	last_seen TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);